@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// loadConfig sets flags from the JSON configuration file name.
+//
+// The file holds a single object whose keys are flag names without the
+// leading dash. Values are strings, booleans, numbers or arrays of strings,
+// which are joined with commas:
+//
+//	{
+//		"type": ["User", "Order"],
+//		"tag": "json",
+//		"exclude-tag": "gorm"
+//	}
+//
+// Flags given on the command line take precedence over the file.
+func loadConfig(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "config" || flag.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown option %q", name, key)
+		}
+		if given[key] {
+			continue
+		}
+		value, err := configValue(values[key])
+		if err != nil {
+			return fmt.Errorf("%s: option %q: %s", name, key, err)
+		}
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("%s: option %q: %s", name, key, err)
+		}
+	}
+	return nil
+}
+
+// configValue returns the flag value text of a decoded JSON value.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return "", fmt.Errorf("array elements must be strings")
+			}
+			list[i] = s
+		}
+		return strings.Join(list, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
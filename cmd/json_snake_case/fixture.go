@@ -0,0 +1,95 @@
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+)
+
+// generateFixture emits a New<Type>Fixture function returning a value of
+// type t with deterministic values for sample JSON: strings are
+// set to the field name, numbers to 1 and booleans to true, slices and
+// maps to empty ones, and fields of generated types to their fixtures
+// under -nested. Other fields keep their zero value. Promoted fields of
+// -flatten-embedded can't be set in the composite literal, and are
+// assigned after it.
+func (g *Generator) generateFixture(t Type, fields []Field) {
+	name, instance := t.Name, t.Name+t.typeArgs()
+	var promoted []Field
+	for _, f := range fields {
+		if f.Promoted && g.fixtureValue(f.Name, f.Type) != "" {
+			promoted = append(promoted, f)
+		}
+	}
+	v := localName("v", fieldTypeNames(name, fields))
+	g.Printf("func New%sFixture%s() %s {\n", name, t.typeParams(), instance)
+	if len(promoted) == 0 {
+		g.Printf("	return %s{\n", instance)
+	} else {
+		g.Printf("	%s := %s{\n", v, instance)
+	}
+	for _, f := range fields {
+		if value := g.fixtureValue(f.Name, f.Type); value != "" && !f.Promoted {
+			g.Printf("		%s: %s,\n", f.Name, value)
+		}
+	}
+	g.Printf("	}\n")
+	if len(promoted) > 0 {
+		for _, f := range promoted {
+			g.Printf("	%s.%s = %s\n", v, f.Name, g.fixtureValue(f.Name, f.Type))
+		}
+		g.Printf("	return %s\n", v)
+	}
+	g.Printf("}\n")
+
+	g.Printf("\n")
+}
+
+// fixtureValue returns the fixture value of the field of type expr, or ""
+// for the zero value. Named types other than generated ones are only
+// given a value if -type-check resolves them to a basic type.
+func (g *Generator) fixtureValue(fieldName string, expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if g.isGenerated(t.Name) {
+			return "New" + t.Name + "Fixture()"
+		}
+		if value := basicFixture(fieldName, t.Name); value != "" {
+			return value
+		}
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return g.typeOf(t, false, 1) + "{}"
+		}
+		return ""
+	case *ast.MapType:
+		return g.typeOf(t, false, 1) + "{}"
+	}
+	if t := g.pkg.typeOf(expr); t != nil {
+		if _, ok := t.(*types.Named); !ok {
+			return ""
+		}
+		if basic, ok := t.Underlying().(*types.Basic); ok {
+			if value := basicFixture(fieldName, basic.Name()); value != "" {
+				return types.ExprString(expr) + "(" + value + ")"
+			}
+		}
+	}
+	return ""
+}
+
+// basicFixture returns the fixture value of a field of the named
+// predeclared type, or "" if it is none with a fixture value.
+func basicFixture(fieldName string, typeName string) string {
+	switch typeName {
+	case "string":
+		return strconv.Quote(fieldName)
+	case "bool":
+		return "true"
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune",
+		"float32", "float64", "complex64", "complex128":
+		return "1"
+	}
+	return ""
+}
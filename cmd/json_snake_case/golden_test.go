@@ -0,0 +1,2152 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Golden is a package to generate code for and the expected output file.
+type Golden struct {
+	name   string
+	types  string
+	args   []string
+	input  string // content of p.go, ' standing for a backquote
+	output string // content of user_json.go, likewise
+}
+
+var golden = []Golden{
+	{"basic", "User", nil, basicIn, basicOut},
+	{"nested", "User,Address", []string{"-nested"}, nestedIn, nestedOut},
+}
+
+const basicIn = `package p
+
+// User is a user of the service.
+type User struct {
+	ID       int
+	UserName string 'json:"name"'
+	Email    *string 'json:",omitempty"'
+	Tags     []string
+	Secret   string 'json:"-"'
+}
+`
+
+const basicOut = `// Code generated by "json_snake_case -type=User"; DO NOT EDIT.
+
+package p
+
+import "encoding/json"
+
+// UserJSON is the JSON serialization view of User.
+//
+// User is a user of the service.
+type UserJSON struct {
+	ID       int      'json:"id"'
+	UserName string   'json:"name"'
+	Email    *string  'json:"email,omitempty"'
+	Tags     []string 'json:"tags"'
+	Secret   string   'json:"-"'
+}
+
+func (m User) MarshalJSON() ([]byte, error) {
+	j := NewUserJSON(&m)
+	return json.Marshal(j)
+}
+
+func NewUserJSON(m *User) *UserJSON {
+	return &UserJSON{
+		ID:       m.ID,
+		UserName: m.UserName,
+		Email:    m.Email,
+		Tags:     m.Tags,
+		Secret:   m.Secret,
+	}
+}
+
+func (j *UserJSON) ToUser() User {
+	return User{
+		ID:       j.ID,
+		UserName: j.UserName,
+		Email:    j.Email,
+		Tags:     j.Tags,
+		Secret:   j.Secret,
+	}
+}
+`
+
+const nestedIn = `package p
+
+type User struct {
+	Name      string
+	Home      Address
+	Work      *Address
+	Addresses []Address
+}
+
+type Address struct {
+	ZipCode string
+}
+`
+
+const nestedOut = `// Code generated by "json_snake_case -type=User,Address -nested"; DO NOT EDIT.
+
+package p
+
+import "encoding/json"
+
+// UserJSON is the JSON serialization view of User.
+type UserJSON struct {
+	Name      string        'json:"name"'
+	Home      AddressJSON   'json:"home"'
+	Work      *AddressJSON  'json:"work"'
+	Addresses []AddressJSON 'json:"addresses"'
+}
+
+func (m User) MarshalJSON() ([]byte, error) {
+	j := NewUserJSON(&m)
+	return json.Marshal(j)
+}
+
+func NewUserJSON(m *User) *UserJSON {
+	v := &UserJSON{
+		Name: m.Name,
+	}
+	v.Home = *NewAddressJSON(&m.Home)
+	if m.Work != nil {
+		v.Work = NewAddressJSON(m.Work)
+	}
+	if m.Addresses != nil {
+		v.Addresses = make([]AddressJSON, len(m.Addresses))
+		for i0 := range m.Addresses {
+			v.Addresses[i0] = *NewAddressJSON(&m.Addresses[i0])
+		}
+	}
+	return v
+}
+
+func (j *UserJSON) ToUser() User {
+	v := User{
+		Name: j.Name,
+	}
+	v.Home = j.Home.ToAddress()
+	if j.Work != nil {
+		p0 := j.Work.ToAddress()
+		v.Work = &p0
+	}
+	if j.Addresses != nil {
+		v.Addresses = make([]Address, len(j.Addresses))
+		for i0 := range j.Addresses {
+			v.Addresses[i0] = j.Addresses[i0].ToAddress()
+		}
+	}
+	return v
+}
+
+// AddressJSON is the JSON serialization view of Address.
+type AddressJSON struct {
+	ZipCode string 'json:"zip_code"'
+}
+
+func (m Address) MarshalJSON() ([]byte, error) {
+	j := NewAddressJSON(&m)
+	return json.Marshal(j)
+}
+
+func NewAddressJSON(m *Address) *AddressJSON {
+	return &AddressJSON{
+		ZipCode: m.ZipCode,
+	}
+}
+
+func (j *AddressJSON) ToAddress() Address {
+	return Address{
+		ZipCode: j.ZipCode,
+	}
+}
+`
+
+func TestGolden(t *testing.T) {
+	for _, test := range golden {
+		t.Run(test.name, func(t *testing.T) {
+			name := strings.ToLower(strings.Split(test.types, ",")[0]) + "_json.go"
+			dir, _ := generate(t, map[string]string{"p.go": test.input}, test.types, test.args...)
+			if got := readFile(t, dir, name); got != ticks(test.output) {
+				t.Errorf("%s: got\n====\n%s====\nwant\n====\n%s====", name, got, ticks(test.output))
+			}
+			vet(t, dir)
+		})
+	}
+}
+
+// userIn is the package most feature tests generate code for.
+const userIn = `package p
+
+// User is a user of the service.
+type User struct {
+	ID       int
+	UserName string 'json:"name"'
+	Email    *string
+	Age      int 'json:",omitempty"'
+}
+`
+
+// A feature is a test of a flag or of a kind of input, checking the
+// generated code for snippets that must and must not be in it. Snippets
+// are compared with runs of white space collapsed, and ' stands for a
+// backquote in them.
+type feature struct {
+	name    string
+	files   map[string]string // default {"p.go": userIn}
+	types   string            // default User
+	args    []string
+	output  string   // file to check, default <first type>_json.go
+	want    []string // snippets of the output
+	notWant []string
+	logs    []string // snippets of the log
+	notLogs []string
+}
+
+var features = []feature{
+	{
+		name:    "yaml inlines embedded structs",
+		files:   map[string]string{"p.go": embeddedIn},
+		args:    []string{"-tag=yaml"},
+		output:  "user_yaml.go",
+		want:    []string{`type UserYAML struct { Base 'yaml:",inline"' UserName string 'yaml:"user_name"' }`, "func NewUserYAML(m *User) *UserYAML"},
+		notWant: []string{"MarshalJSON"},
+	},
+	{
+		// TOML encoders promote embedded structs like encoding/json.
+		name:    "toml",
+		files:   map[string]string{"p.go": embeddedIn},
+		args:    []string{"-tag=toml"},
+		output:  "user_toml.go",
+		want:    []string{`type UserTOML struct { Base UserName string 'toml:"user_name"' }`, "func NewUserTOML(m *User) *UserTOML", "func (j *UserTOML) ToUser() User"},
+		notWant: []string{"MarshalJSON", "encoding/json", "json:"},
+	},
+	{
+		// encoding/json promotes the fields of embedded structs itself.
+		name:    "json promotes embedded structs",
+		files:   map[string]string{"p.go": embeddedIn},
+		want:    []string{`type UserJSON struct { Base UserName string 'json:"user_name"' }`},
+		notWant: []string{"inline"},
+	},
+	{
+		name: "indent",
+		args: []string{"-indent=  "},
+		want: []string{`return json.MarshalIndent(j, "", "  ")`},
+	},
+	{
+		name:  "package named json",
+		files: map[string]string{"p.go": strings.Replace(userIn, "package p", "package json", 1)},
+		want:  []string{"package json", `import jsonpkg "encoding/json"`, "return jsonpkg.Marshal(j)"},
+	},
+	{
+		name: "json declared in the package",
+		files: map[string]string{"p.go": userIn, "json.go": `package p
+var json = "declared"
+`},
+		want: []string{`import jsonpkg "encoding/json"`, "return jsonpkg.Marshal(j)"},
+	},
+	{
+		name: "exclude-tag",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'json:"name" gorm:"unique" validate:"required"'
+}
+`},
+		args:    []string{"-exclude-tag=gorm"},
+		want:    []string{`json:"name"`, `validate:"required"`},
+		notWant: []string{`gorm:"`},
+	},
+	{
+		name: "tag keys keep their order",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'yaml:"n" json:"name" db:"user_name"'
+	Email    string 'db:"email"'
+}
+`},
+		want: []string{`'yaml:"n" json:"name" db:"user_name"'`, `'db:"email" json:"email"'`},
+	},
+	{
+		name:    "only-tagged",
+		files:   map[string]string{"p.go": mixedTagsIn},
+		args:    []string{"-only-tagged"},
+		want:    []string{`type UserJSON struct { UserName string 'json:"name"' }`, "UserName: m.UserName,"},
+		notWant: []string{"ID:", "Email", "cache"},
+		logs:    []string{"User: 1 fields, 3 skipped", "wrote user_json.go: 1 types, 1 fields, 3 skipped"},
+	},
+	{
+		name:    "only-tagged with a key",
+		files:   map[string]string{"p.go": mixedTagsIn},
+		args:    []string{"-only-tagged=db"},
+		want:    []string{`type UserJSON struct { Email string 'db:"email" json:"email"' }`},
+		notWant: []string{"UserName", "cache"},
+	},
+	{
+		name: "require-tag",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	ID       int    'api:"public"'
+	Password string 'api:"private"'
+	Email    string 'api:"public,readonly"'
+	Note     string 'api:"publicly"'
+	Cache    string
+}
+`},
+		args:    []string{"-require-tag=api:public"},
+		want:    []string{`type UserJSON struct { ID int 'api:"public" json:"id"' Email string 'api:"public,readonly" json:"email"' }`},
+		notWant: []string{"Password", "Note", "Cache"},
+		logs:    []string{"User: 2 fields, 3 skipped"},
+	},
+	{
+		name: "snake tag options",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'snake:",omitempty"'
+	Email    string
+	Age      int 'json:"years,string" snake:",omitempty,string" db:"age"'
+}
+`},
+		want:    []string{`UserName string 'json:"user_name,omitempty"' Email string 'json:"email"' Age int 'json:"years,string,omitempty" db:"age"'`},
+		notWant: []string{"snake:"},
+	},
+	{
+		name: "snake tag options with -omitempty",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'snake:",omitempty"'
+	Email    string
+}
+`},
+		args: []string{"-omitempty"},
+		want: []string{`UserName string 'json:"user_name,omitempty"' Email string 'json:"email,omitempty"'`},
+	},
+	{
+		name: "non-json tags kept byte for byte",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Foo  string 'xml:"Foo"'
+	Attr string 'xml:"urn:x Attr,attr"'
+	Path string 'xml:"a>B-c.d"'
+	Q    string 'xml:"Q\"x"'
+}
+`},
+		want: []string{
+			`Foo string 'xml:"Foo" json:"foo"'`,
+			`Attr string 'xml:"urn:x Attr,attr" json:"attr"'`,
+			`Path string 'xml:"a>B-c.d" json:"path"'`,
+			`Q string 'xml:"Q\"x" json:"q"'`,
+		},
+	},
+	{
+		name:    "warn-fields at the threshold",
+		files:   map[string]string{"p.go": userIn},
+		args:    []string{"-warn-fields=4"},
+		notLogs: []string{"warning:"},
+	},
+	{
+		name:  "warn-fields above the threshold",
+		files: map[string]string{"p.go": userIn},
+		args:  []string{"-warn-fields=3"},
+		logs:  []string{"warning: User has 4 fields, more than -warn-fields=3; consider splitting it"},
+	},
+	{
+		name:    "grouped type declarations",
+		files:   map[string]string{"p.go": groupedIn},
+		types:   "A,B,E",
+		args:    []string{"-nested", "-source-pos"},
+		want:    []string{"// A is the first. type AJSON struct", "// BJSON is the JSON serialization view of B (from p.go:7). type BJSON struct { Next *AJSON 'json:\"next\"' }", "// E stands alone. type EJSON struct"},
+		notWant: []string{"Models of the API", "CJSON", "DJSON"},
+	},
+	{
+		name:    "grouped type declarations with -type=*",
+		files:   map[string]string{"p.go": groupedIn},
+		types:   "*",
+		output:  "p_json.go",
+		want:    []string{"type AJSON struct", "type BJSON struct", "type EJSON struct"},
+		notWant: []string{"Models of the API", "CJSON", "DJSON"},
+		logs:    []string{"wrote p_json.go: 3 types"},
+	},
+	{
+		name: "anonymous interface field",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Name   string
+	Reader interface{ Read([]byte) (int, error) }
+	Any    interface{}
+}
+`},
+		args: []string{"-v"},
+		want: []string{
+			`Reader interface{ Read([]byte) (int, error) } 'json:"reader"'`,
+			`Any interface{} 'json:"any"'`,
+			"Reader: m.Reader,",
+			"Reader: j.Reader,",
+		},
+		logs: []string{"User.Reader: anonymous interface type is copied as is"},
+	},
+	{
+		name:   "variant",
+		args:   []string{"-variant=v2"},
+		output: "user_json_v2.go",
+		want:   []string{`// Code generated by "json_snake_case -type=User -variant=v2"; DO NOT EDIT. //go:build v2 package p`},
+	},
+	{
+		name: "build-tag",
+		args: []string{"-build-tag=linux && !appengine"},
+		want: []string{"//go:build linux && !appengine package p"},
+	},
+	{
+		name:   "variant with a build-tag",
+		args:   []string{"-variant=gen", "-build-tag=gen || tools"},
+		output: "user_json_gen.go",
+		want:   []string{"//go:build gen || tools package p"},
+	},
+	{
+		name: "doc comment",
+		want: []string{"// UserJSON is the JSON serialization view of User. // // User is a user of the service. type UserJSON struct"},
+	},
+	{
+		// The doc comment of a group of types documents none of them.
+		name: "doc comment of a type in a group",
+		files: map[string]string{"p.go": `package p
+// Types of the service.
+type (
+	// User is a user.
+	User struct{ Name string }
+	Order struct{ ID int }
+)
+`},
+		types: "User,Order",
+		want: []string{
+			"// UserJSON is the JSON serialization view of User. // // User is a user. type UserJSON struct",
+			"} // OrderJSON is the JSON serialization view of Order. type OrderJSON struct",
+		},
+		notWant: []string{"Types of the service"},
+	},
+	{
+		name: "omitempty for listed types",
+		files: map[string]string{"p.go": userIn + `
+type Order struct{ Total int }
+`},
+		types:   "User,Order",
+		args:    []string{"-omitempty=Order"},
+		want:    []string{`Total int 'json:"total,omitempty"'`, `UserName string 'json:"name"'`, `ID int 'json:"id"'`},
+		notWant: []string{`json:"name,omitempty"`, `json:"id,omitempty"`},
+	},
+	{
+		name: "omitzero",
+		files: map[string]string{"p.go": `package p
+type Stamp struct{ Unix int64 }
+type User struct {
+	CreatedAt Stamp 'json:",omitzero"'
+	Both      int 'json:",omitempty,omitzero"'
+	Named     int 'json:"nm,omitzero"'
+	Plain     int
+}
+type Order struct{ Count int 'json:",omitempty"' }
+`},
+		types: "User,Order",
+		args:  []string{"-omitzero=Order"},
+		want: []string{
+			`CreatedAt Stamp 'json:"created_at,omitzero"' Both int 'json:"both,omitempty,omitzero"' Named int 'json:"nm,omitzero"' Plain int 'json:"plain"'`,
+			`Count int 'json:"count,omitempty,omitzero"'`,
+		},
+	},
+	{
+		name: "omitzero for all types",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Named  int 'json:"nm,omitzero"'
+	Plain  int
+	Secret int 'json:"-"'
+}
+`},
+		args:    []string{"-omitzero", "-omitempty"},
+		want:    []string{`Named int 'json:"nm,omitzero,omitempty"' Plain int 'json:"plain,omitempty,omitzero"' Secret int 'json:"-"'`},
+		notWant: []string{"omitzero,omitzero", "omitempty,omitempty"},
+	},
+	{
+		name: "omitzero in the schema",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Count int 'json:",omitzero"'
+	Plain int
+}
+`},
+		args:   []string{"-schema"},
+		output: "user_json.schema.json",
+		want:   []string{`"required": [ "plain" ]`},
+	},
+	{
+		name: "omitempty for all types",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'json:"name"'
+	Age      int 'json:",omitempty"'
+	Secret   string 'json:"-"'
+}
+`},
+		args: []string{"-omitempty"},
+		want: []string{`UserName string 'json:"name,omitempty"'`, `Age int 'json:"age,omitempty"'`, `Secret string 'json:"-"'`},
+	},
+	{
+		name:  "gen-validate",
+		files: map[string]string{"p.go": validateIn},
+		args:  []string{"-gen-validate", "-v"},
+		want: []string{
+			`import ( "encoding/json" "errors" )`,
+			`func (m User) Validate() error { if m.UserName == "" { return errors.New("name is required") } if m.Email == nil { return errors.New("email is required") } return nil }`,
+		},
+		notWant: []string{"m.Bio ==", "m.Age =="},
+		logs:    []string{"User.Age: required int is not validated"},
+	},
+	{
+		// The errors package is only imported when a field is checked.
+		name:    "gen-validate without required fields",
+		files:   map[string]string{"p.go": "package p\n\ntype User struct {\n\tAge int 'validate:\"required\"'\n}\n"},
+		args:    []string{"-gen-validate"},
+		want:    []string{`import "encoding/json"`, "func (m User) Validate() error { return nil }"},
+		notWant: []string{`"errors"`},
+	},
+	{
+		name: "empty json names",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'json:""'
+	Age      int 'json:",omitempty"'
+}
+`},
+		want:    []string{`'json:"user_name"'`, `'json:"age,omitempty"'`},
+		notWant: []string{`json:""`},
+	},
+	{
+		name:  "key-prefix",
+		files: map[string]string{"p.go": metaIn},
+		types: "Meta",
+		args:  []string{"-key-prefix=meta"},
+		want:  []string{`CreatedAt int64 'json:"meta_created_at"'`, `UpdatedAt int64 'json:"meta_updated_at,omitempty"'`, `Author string 'json:"by"'`, `Secret string 'json:"-"'`},
+	},
+	{
+		name:  "key-prefix with force-rename",
+		files: map[string]string{"p.go": metaIn},
+		types: "Meta",
+		args:  []string{"-key-prefix=meta", "-force-rename"},
+		want:  []string{`CreatedAt int64 'json:"meta_created_at"'`, `Author string 'json:"meta_by"'`, `Secret string 'json:"-"'`},
+	},
+	{
+		name: "any",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Data  any
+	Items []any
+}
+`},
+		want: []string{`Data any 'json:"data"'`, `Items []any 'json:"items"'`, "Data: m.Data,", "Data: j.Data,"},
+	},
+	{
+		// The pool is declared once and shared by the types of the file.
+		name: "buffer-pool",
+		files: map[string]string{"p.go": userIn + `
+type Order struct{ Total int }
+`},
+		types: "User,Order",
+		args:  []string{"-buffer-pool", "-indent=\t"},
+		want: []string{
+			`import ( "bytes" "encoding/json" "sync" )`,
+			"var userJSONEncoders = sync.Pool{",
+			`e.enc.SetIndent("", "\t")`,
+			"var userJSONValues = sync.Pool{ New: func() interface{} { return new(UserJSON) }, }",
+			"func (m User) MarshalJSON() ([]byte, error) { j := userJSONValues.Get().(*UserJSON) defer func() { *j = UserJSON{} userJSONValues.Put(j) }() *j = UserJSON{ ID: m.ID,",
+			"func (m Order) MarshalJSON() ([]byte, error) { j := orderJSONValues.Get().(*OrderJSON) defer func() { *j = OrderJSON{} orderJSONValues.Put(j) }() *j = OrderJSON{ Total: m.Total, } return userJSONMarshal(j) }",
+		},
+		notWant: []string{"orderJSONEncoders", "orderJSONMarshal", "MarshalIndent"},
+	},
+	{
+		// Generic types can't have package-level pools of their own.
+		name:  "buffer-pool with a generic type",
+		files: map[string]string{"p.go": "package p\n\ntype Über[T any] struct{ Value T }\n"},
+		types: "Über",
+		args:  []string{"-buffer-pool", "-check-output"},
+		want: []string{
+			"var überJSONEncoders = sync.Pool{",
+			"func (m Über[T]) MarshalJSON() ([]byte, error) { j := NewÜberJSON(&m) return überJSONMarshal(j) }",
+		},
+		notWant: []string{"Values"},
+	},
+	{
+		name: "schema",
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	// UserName is the name shown to others.
+	UserName string
+	Age      int 'json:",omitempty"' // in years
+	Home     *Address
+	Avatar   []byte
+}
+`},
+		types:  "User,Address",
+		args:   []string{"-schema"},
+		output: "user_json.schema.json",
+		want: []string{
+			`"$schema": "http://json-schema.org/draft-07/schema#"`,
+			`"user_name": { "type": "string", "description": "UserName is the name shown to others." }`,
+			`"age": { "type": "integer", "description": "in years" }`,
+			`"home": { "$ref": "#/definitions/Address" }`,
+			`"avatar": { "type": "string", "format": "byte" }`,
+			`"required": [ "user_name", "home", "avatar" ]`,
+		},
+	},
+	{
+		name: "strip-field-prefix",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	DBUserName  string
+	DBUserEmail string
+	DBase       string
+	Name        string
+	DBNick      string 'json:"nick"'
+}
+`},
+		args: []string{"-strip-field-prefix=DB"},
+		want: []string{
+			`DBUserName string 'json:"user_name"'`,
+			`DBUserEmail string 'json:"user_email"'`,
+			`DBase string 'json:"d_base"'`,
+			`Name string 'json:"name"'`,
+			`DBNick string 'json:"nick"'`,
+			"DBUserName: m.DBUserName,",
+		},
+	},
+	{
+		name: "nested self-reference",
+		files: map[string]string{"p.go": `package p
+type Node struct {
+	Value    int
+	Parent   *Node
+	Children []*Node
+	Index    map[string]Node
+}
+`},
+		types: "Node",
+		args:  []string{"-nested"},
+		want: []string{
+			`Parent *NodeJSON 'json:"parent"' Children []*NodeJSON 'json:"children"' Index map[string]NodeJSON 'json:"index"'`,
+			"v.Parent = NewNodeJSON(m.Parent)",
+			"v.Children[i0] = NewNodeJSON(m.Children[i0])",
+			"c0 = *NewNodeJSON(&e0)",
+		},
+	},
+	{
+		name: "nested mutual references",
+		files: map[string]string{"p.go": `package p
+type A struct {
+	Name string
+	B    *B
+}
+type B struct {
+	As []A
+}
+`},
+		types: "A,B",
+		args:  []string{"-nested"},
+		want: []string{
+			`type AJSON struct { Name string 'json:"name"' B *BJSON 'json:"b"' }`,
+			`type BJSON struct { As []AJSON 'json:"as"' }`,
+			"v.B = NewBJSON(m.B)",
+			"v.As[i0] = *NewAJSON(&m.As[i0])",
+		},
+	},
+	{
+		name: "nested composite field types",
+		files: map[string]string{"p.go": `package p
+type Address struct{ ZipCode string }
+type User struct {
+	Tags   *[]string
+	ByCity map[string][]*Address
+	Homes  *[]Address
+	Grid   [2][]*Address
+}
+`},
+		types: "User,Address",
+		args:  []string{"-nested"},
+		want: []string{
+			`Tags *[]string 'json:"tags"'`,
+			`ByCity map[string][]*AddressJSON 'json:"by_city"'`,
+			`Homes *[]AddressJSON 'json:"homes"'`,
+			`Grid [2][]*AddressJSON 'json:"grid"'`,
+			"Tags: m.Tags,",
+			"for k0, e0 := range m.ByCity { var c0 []*AddressJSON",
+			"v.Homes = new([]AddressJSON)",
+			"v.Homes = new([]Address)",
+		},
+	},
+	{
+		name: "nested any",
+		files: map[string]string{"p.go": `package p
+type Address struct{ ZipCode string }
+type User struct {
+	Data  any
+	Items map[string]any
+	Home  Address
+}
+`},
+		types:   "User,Address",
+		args:    []string{"-nested"},
+		want:    []string{`Data any 'json:"data"' Items map[string]any 'json:"items"' Home AddressJSON 'json:"home"'`, "v := &UserJSON{ Data: m.Data, Items: m.Items, }"},
+		notWant: []string{"anyJSON"},
+	},
+	{
+		name:    "all types skip constraint interfaces",
+		files:   map[string]string{"p.go": constraintIn},
+		types:   "*",
+		args:    []string{"-v"},
+		output:  "p_json.go",
+		want:    []string{"type UserJSON struct", "type OrderJSON struct"},
+		notWant: []string{"NumberJSON"},
+		logs:    []string{"Number is not a struct, skipping"},
+	},
+	{
+		name:    "constraint interface",
+		files:   map[string]string{"p.go": constraintIn},
+		types:   "Number,User",
+		args:    []string{"-v"},
+		output:  "number_json.go",
+		want:    []string{"type UserJSON struct"},
+		notWant: []string{"NumberJSON", "OrderJSON"},
+		logs:    []string{"Number is not a struct, skipping"},
+	},
+	{
+		// A pointer to a generated type may point to the type itself.
+		name: "deref-pointers keeps pointers to generated types",
+		files: map[string]string{"p.go": `package p
+type Node struct {
+	Value  *int
+	Parent *Node
+}
+`},
+		types: "Node",
+		args:  []string{"-nested", "-deref-pointers", "-v"},
+		want:  []string{`Value int 'json:"value"' Parent *NodeJSON 'json:"parent"'`},
+		logs:  []string{"Node.Parent: pointer to generated type Node is kept"},
+	},
+	{
+		name: "also-tag",
+		files: map[string]string{"p.go": `package p
+type Base struct{ ID int }
+type User struct {
+	Base
+	UserName string 'db:"user_name"'
+	Email    string 'json:",omitempty" bson:"mail"'
+}
+`},
+		args: []string{"-also-tag=bson,yaml"},
+		want: []string{
+			`Base 'bson:",inline" yaml:",inline"'`,
+			`UserName string 'db:"user_name" json:"user_name" bson:"user_name" yaml:"user_name"'`,
+			`Email string 'json:"email,omitempty" bson:"mail" yaml:"email"'`,
+		},
+	},
+	{
+		name: "tag list",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string
+	Email    string 'bson:"mail"'
+}
+`},
+		args: []string{"-tag=json,yaml,bson"},
+		want: []string{
+			`type UserJSON struct { UserName string 'json:"user_name" yaml:"user_name" bson:"user_name"' Email string 'bson:"mail" json:"email" yaml:"email"' }`,
+			"func (m User) MarshalJSON() ([]byte, error)",
+		},
+	},
+	{
+		name:    "tag list with a non-json key first",
+		args:    []string{"-tag=bson,json"},
+		output:  "user_bson.go",
+		want:    []string{`type UserBSON struct`, `ID int 'bson:"id" json:"id"'`},
+		notWant: []string{"MarshalJSON"},
+	},
+	{
+		// Types whose names are those of the locals rename the locals.
+		name: "field types named like the locals",
+		files: map[string]string{"p.go": `package p
+type m struct{ A int }
+type j struct{ B int }
+type v struct{ C int }
+type User struct {
+	M m
+	J *j
+	V []v
+}
+`},
+		types: "User,v",
+		args:  []string{"-nested"},
+		want:  []string{"func NewUserJSON(m2 *User) *UserJSON {", "func (j2 *UserJSON) ToUser() User {", "v2 := &UserJSON{ M: m2.M, J: m2.J, }"},
+	},
+	{
+		name: "style",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserID     int
+	HTTPServer string
+	Name       string 'json:"full_name"'
+}
+`},
+		args: []string{"-style=camelPreserveInitialisms"},
+		want: []string{`UserID int 'json:"userID"'`, `HTTPServer string 'json:"httpServer"'`, `Name string 'json:"full_name"'`},
+	},
+	{
+		name: "proto style",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserID    int
+	HTTP2Port int
+}
+`},
+		args: []string{"-style=proto"},
+		want: []string{`UserID int 'json:"userId"'`, `HTTP2Port int 'json:"http2Port"'`},
+	},
+	{
+		name:  "since-go-version with a generic field type",
+		files: map[string]string{"p.go": genericFieldIn},
+		args:  []string{"-since-go-version=1.17", "-build-tag=gen || tools"},
+		want:  []string{"DO NOT EDIT. //go:build (gen || tools) && go1.18 package p", `Items List[int] 'json:"items"'`},
+	},
+	{
+		name:  "since-go-version with a generic type",
+		files: map[string]string{"p.go": genericTypesIn},
+		types: "Pair",
+		args:  []string{"-since-go-version=1.17"},
+		want:  []string{"DO NOT EDIT. //go:build go1.18 package p"},
+	},
+	{
+		name: "since-go-version with any",
+		files: map[string]string{"p.go": `package p
+type User struct{ Data any }
+`},
+		args: []string{"-since-go-version=go1.17"},
+		want: []string{"DO NOT EDIT. //go:build go1.18 package p"},
+	},
+	{
+		name:    "since-go-version without newer features",
+		args:    []string{"-since-go-version=1.17"},
+		notWant: []string{"//go:build"},
+	},
+	{
+		name: "since-go-version with any declared in the package",
+		files: map[string]string{"p.go": `package p
+type any struct{}
+type User struct{ Data any }
+`},
+		args:    []string{"-since-go-version=1.17"},
+		notWant: []string{"//go:build"},
+	},
+	{
+		name:    "since-go-version as new as the features",
+		files:   map[string]string{"p.go": genericFieldIn},
+		args:    []string{"-since-go-version=1.18"},
+		notWant: []string{"//go:build"},
+	},
+	{
+		name: "skip-noop",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string 'json:"user_name"'
+	Age      int    'json:"age,omitempty" db:"age"'
+}
+type Order struct {
+	OrderID int 'json:"order_id"'
+	Total   int
+}
+`},
+		types:   "User,Order",
+		args:    []string{"-skip-noop", "-v"},
+		want:    []string{"type OrderJSON struct", "func (m Order) MarshalJSON()"},
+		notWant: []string{"UserJSON", "func (m User)"},
+		logs:    []string{"User: source tags match the generated ones, skipping", "wrote user_json.go: 1 types, 2 fields"},
+	},
+	{
+		name:    "type-check",
+		files:   map[string]string{"p.go": typeCheckIn},
+		args:    []string{"-type-check", "-schema", "-v"},
+		output:  "user_json.schema.json",
+		want:    []string{`"level": { "type": "integer" }`, `"name": { "type": "string" }`, `"stamp": {}`},
+		notWant: []string{"on_event"},
+		logs:    []string{"User.OnEvent: Handler is copied as is, but its underlying type is func(), which encoding/json cannot marshal"},
+	},
+	{
+		// Without type information, types declared in the package are
+		// not resolved.
+		name:   "schema without type-check",
+		files:  map[string]string{"p.go": typeCheckIn},
+		args:   []string{"-schema"},
+		output: "user_json.schema.json",
+		want:   []string{`"level": {}`, `"name": {}`},
+	},
+	{
+		name: "directive comments",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	// UserName is shown to others.
+	//nolint:misspell
+	UserName string
+	Secret   string //nolint:gosec // not a credential
+	Age      int    // in years
+	//the name, written without a space
+	//lint:ignore U1000 kept for the API
+	Nick string //nolint
+	//TODO: drop it
+	//go:
+	Legacy string //Deprecated: use Nick
+}
+`},
+		want: []string{
+			`type UserJSON struct { //nolint:misspell UserName string 'json:"user_name"' Secret string 'json:"secret"' //nolint:gosec // not a credential`,
+			`Age int 'json:"age"' //lint:ignore U1000 kept for the API Nick string 'json:"nick"' //nolint Legacy string 'json:"legacy"' }`,
+		},
+		notWant: []string{"shown to others", "in years", "the name", "TODO", "//go:", "Deprecated"},
+	},
+	{
+		name: "gen-fixture with flatten-embedded",
+		files: map[string]string{"p.go": `package p
+type Base struct {
+	ID   int
+	Kind string
+}
+type User struct {
+	Base
+	UserName string
+}
+`},
+		types: "User,Base",
+		args:  []string{"-gen-fixture", "-flatten-embedded"},
+		want: []string{
+			`func NewUserFixture() User { v := User{ UserName: "UserName", } v.ID = 1 v.Kind = "Kind" return v }`,
+			`func NewBaseFixture() Base { return Base{ ID: 1, Kind: "Kind", } }`,
+		},
+	},
+	{
+		name: "empty and interpreted tag literals",
+		files: map[string]string{"p.go": `package p
+type Base struct{ ID int }
+type User struct {
+	Base ''
+	Name string ''
+	Nick string "json:\"nick_name\" db:\"nick\""
+}
+`},
+		want: []string{"type UserJSON struct { Base Name string 'json:\"name\"' Nick string 'json:\"nick_name\" db:\"nick\"' }"},
+	},
+	{
+		name: "marker comments",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	// Nickname is shown to others.
+	// +optional
+	// +kubebuilder:validation:MaxLength=64
+	Nickname string
+	Age      int // +optional
+	// + not a marker
+	Email string
+}
+`},
+		want: []string{
+			`type UserJSON struct { // +optional // +kubebuilder:validation:MaxLength=64 Nickname string 'json:"nickname"' Age int 'json:"age"' // +optional Email string 'json:"email"' }`,
+		},
+		notWant: []string{"shown to others", "not a marker"},
+	},
+	{
+		name:  "unicode field names",
+		files: map[string]string{"p.go": "package p\ntype User struct {\n\tÜberName string\n\tÉtéID    int\n}\n"},
+		want:  []string{"ÜberName string 'json:\"über_name\"'", "ÉtéID int 'json:\"été_id\"'"},
+	},
+	{
+		name:  "max-depth 1",
+		files: map[string]string{"p.go": maxDepthIn},
+		types: "User,Address",
+		args:  []string{"-nested", "-max-depth=1"},
+		want: []string{
+			`Home AddressJSON 'json:"home"' Work *AddressJSON 'json:"work"' Homes []Address 'json:"homes"' Grid [][]Address 'json:"grid"' ByCity map[string]Address 'json:"by_city"'`,
+			"v := &UserJSON{ Homes: m.Homes, Grid: m.Grid, ByCity: m.ByCity, }",
+			"v.Home = *NewAddressJSON(&m.Home)",
+		},
+	},
+	{
+		name:  "max-depth 2",
+		files: map[string]string{"p.go": maxDepthIn},
+		types: "User,Address",
+		args:  []string{"-nested", "-max-depth=2"},
+		want: []string{
+			`Home AddressJSON 'json:"home"' Work *AddressJSON 'json:"work"' Homes []AddressJSON 'json:"homes"' Grid [][]Address 'json:"grid"' ByCity map[string]AddressJSON 'json:"by_city"'`,
+			"v := &UserJSON{ Grid: m.Grid, }",
+		},
+	},
+	{
+		name:  "named collection types",
+		files: map[string]string{"p.go": namedCollectionsIn},
+		types: "Node",
+		args:  []string{"-nested"},
+		want:  []string{`Tags TagList 'json:"tags"' Children Nodes 'json:"children"' Next *NodeJSON 'json:"next"'`, "v := &NodeJSON{ Tags: m.Tags, Children: m.Children, }"},
+	},
+	{
+		name:  "named collection types with -type-check",
+		files: map[string]string{"p.go": namedCollectionsIn},
+		types: "Node",
+		args:  []string{"-nested", "-type-check"},
+		want:  []string{`Tags TagList 'json:"tags"' Children Nodes 'json:"children"' Next *NodeJSON 'json:"next"'`, "v := &NodeJSON{ Tags: m.Tags, Children: m.Children, }"},
+	},
+	{
+		name:  "null-type",
+		files: map[string]string{"p.go": optionalIn},
+		args:  []string{"-null-type=Optional"},
+		want: []string{
+			`NickName *string 'json:"nick_name"' Age *int 'json:"age,omitempty"' Name string 'json:"name"'`,
+			"if m.NickName.IsSet() { x := m.NickName.Value() v.NickName = &x }",
+			"// NickName is not converted back from *string.",
+		},
+	},
+	{
+		name:  "embedded generated type",
+		files: map[string]string{"p.go": embeddedGeneratedIn},
+		types: "Outer,Base",
+		want:  []string{"type OuterJSON struct { Base Name string 'json:\"name\"' ID string 'json:\"id\"' }"},
+		logs:  []string{"warning: Outer embeds Base, whose MarshalJSON is promoted to OuterJSON and marshals Base alone; use -nested or -flatten-embedded"},
+	},
+	{
+		name:    "flatten-embedded",
+		files:   map[string]string{"p.go": embeddedGeneratedIn},
+		types:   "Outer,Base",
+		args:    []string{"-flatten-embedded"},
+		want:    []string{"type OuterJSON struct { Name string 'json:\"name\"' ID string 'json:\"id\"' Email string 'json:\"email\"' }", "Email: m.Email,", "v.Email = j.Email"},
+		notLogs: []string{"warning:"},
+	},
+	{
+		name:  "value-constructor",
+		files: map[string]string{"p.go": nestedIn},
+		types: "User,Address",
+		args:  []string{"-nested", "-value-constructor"},
+		want:  []string{"func NewUserJSON(m *User) UserJSON {", "func NewAddressJSON(m *Address) AddressJSON {", "j := NewUserJSON(&m) return json.Marshal(&j)"},
+	},
+	{
+		name:  "gen-slice-helper",
+		files: map[string]string{"p.go": "package p\n\ntype Box[T any] struct{ Value T }\n"},
+		types: "Box",
+		args:  []string{"-gen-slice-helper", "-check-output"},
+		want:  []string{"func NewBoxJSONSlice[T any](ms []Box[T]) []*BoxJSON[T] { if ms == nil { return nil } js := make([]*BoxJSON[T], len(ms)) for i := range ms { js[i] = NewBoxJSON(&ms[i]) } return js }"},
+	},
+	{
+		name:  "gen-slice-helper with -with-context and -value-constructor",
+		files: map[string]string{"p.go": userIn},
+		types: "User",
+		args:  []string{"-gen-slice-helper", "-with-context", "-value-constructor", "-check-output"},
+		want:  []string{"func NewUserJSONSlice(ctx context.Context, ms []User) []UserJSON {", "js := make([]UserJSON, len(ms))", "js[i] = NewUserJSON(ctx, &ms[i])"},
+	},
+	{
+		name:  "anonymous struct fields",
+		files: map[string]string{"p.go": anonymousStructIn},
+		types: "User",
+		want: []string{
+			`Addr struct { Street string 'json:"street"' ZipCode string 'json:"zip,omitempty" db:"zip"' Geo *struct { Lat float64 'json:"lat"' Lng float64 'json:"lng"' } 'json:"geo"' } 'json:"addr"'`,
+			`Homes []struct { Home Address 'json:"home"' } 'json:"homes"' Empty struct{} 'json:"empty"'`,
+			"v := &UserJSON{ Empty: m.Empty, } v.Addr.Street = m.Addr.Street v.Addr.ZipCode = m.Addr.ZipCode",
+			"v.Addr.Geo = new(struct { Lat float64 Lng float64 })",
+		},
+	},
+	{
+		name:  "anonymous struct fields with -max-depth",
+		files: map[string]string{"p.go": anonymousStructIn},
+		types: "User,Address",
+		args:  []string{"-nested", "-max-depth=2"},
+		want: []string{
+			`Homes []struct { Home Address 'json:"home"' } 'json:"homes"'`,
+			"v.Homes[i0].Home = m.Homes[i0].Home",
+		},
+	},
+	{
+		name:  "generic types",
+		files: map[string]string{"p.go": genericTypesIn},
+		types: "Page,Pair",
+		args:  []string{"-gen-validate", "-gen-writer", "-gen-partial", "-gen-fixture", "-check-output"},
+		want: []string{
+			"type PageJSON[T any] struct { Items []T 'json:\"items\"' First *T 'json:\"first\"' ByName map[string]T 'json:\"by_name\"'",
+			"func (m Page[T]) MarshalJSON() ([]byte, error) { j := NewPageJSON(&m)",
+			"func (m Page[T]) MarshalJSONFields(fields map[string]bool) ([]byte, error) {",
+			"func (m Page[T]) WriteJSON(w io.Writer) error {",
+			"func NewPageJSON[T any](m *Page[T]) *PageJSON[T] {",
+			"func (j *PageJSON[T]) ToPage() Page[T] {",
+			"func (m Page[T]) Validate() error {",
+			"func NewPageFixture[T any]() Page[T] {",
+			"type PairJSON[K comparable, V Number] struct {",
+			"func (m Pair[K, V]) MarshalJSON() ([]byte, error) {",
+			"func NewPairJSON[K comparable, V Number](m *Pair[K, V]) *PairJSON[K, V] {",
+			"func (j *PairJSON[K, V]) ToPair() Pair[K, V] {",
+		},
+	},
+	{
+		// Named func types are copied by name like any other type, and
+		// only reported if they don't marshal themselves.
+		name:    "named func types",
+		files:   map[string]string{"p.go": funcTypesIn},
+		args:    []string{"-type-check", "-v"},
+		want:    []string{`H Handler 'json:"h"' Plain Callback 'json:"-"'`, "H: m.H,", "Plain: m.Plain,"},
+		logs:    []string{"User.Plain: Callback is copied as is, but its underlying type is func(), which encoding/json cannot marshal"},
+		notLogs: []string{"User.H:"},
+	},
+	{
+		name:    "dash tags",
+		files:   map[string]string{"p.go": dashIn},
+		args:    []string{"-omitempty"},
+		want:    []string{`Skip string 'json:"-"' Dash string 'json:"-,omitempty"' Name string 'json:"name,omitempty"'`},
+		notWant: []string{`"-,,`, `Skip string 'json:"-,`},
+	},
+	{
+		name:    "dash tags in the schema",
+		files:   map[string]string{"p.go": dashIn},
+		args:    []string{"-schema"},
+		output:  "user_json.schema.json",
+		want:    []string{`"properties": { "-": { "type": "string" }, "name": { "type": "string" } }, "required": [ "-", "name" ]`},
+		notWant: []string{`"skip"`},
+	},
+	{
+		name: "snake-numbers",
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Address2 string
+	S3Bucket string
+}
+`},
+		args: []string{"-snake-numbers=separated"},
+		want: []string{`Address2 string 'json:"address_2"'`, `S3Bucket string 'json:"s_3_bucket"'`},
+	},
+	{
+		name: "composite field types",
+		files: map[string]string{"p.go": `package p
+type Address struct{ ZipCode string }
+type User struct {
+	Tags    *[]string
+	Counts  []map[string]int
+	ByCity  map[string][]*Address
+	Grid    [3]map[*Address][]*[]int
+}
+`},
+		want: []string{
+			`Tags *[]string 'json:"tags"'`,
+			`Counts []map[string]int 'json:"counts"'`,
+			`ByCity map[string][]*Address 'json:"by_city"'`,
+			`Grid [3]map[*Address][]*[]int 'json:"grid"'`,
+			"ByCity: m.ByCity,",
+		},
+	},
+	{
+		// The test calling the helper is vetted with it, in package p.
+		name: "test",
+		files: map[string]string{"p.go": userIn, "p_test.go": `package p
+import "testing"
+func TestMarshal(t *testing.T) {
+	if _, err := MarshalUserJSON(&User{}); err != nil {
+		t.Fatal(err)
+	}
+}
+`},
+		args:    []string{"-test"},
+		output:  "user_json_test.go",
+		want:    []string{"package p\n", "func MarshalUserJSON(m *User) ([]byte, error) { j := NewUserJSON(m) return json.Marshal(j) }"},
+		notWant: []string{"package p_test", "MarshalJSON()"},
+	},
+	{
+		name:   "test with value-constructor and with-context",
+		args:   []string{"-test", "-value-constructor", "-with-context"},
+		output: "user_json_test.go",
+		want:   []string{"func MarshalUserJSON(m *User) ([]byte, error) { j := NewUserJSON(context.TODO(), m) return json.Marshal(&j) }"},
+	},
+	{
+		name: "test generic",
+		files: map[string]string{"p.go": `package p
+type Page[T any] struct{ Items []T }
+`},
+		types:  "Page",
+		args:   []string{"-test"},
+		output: "page_json_test.go",
+		want:   []string{"func MarshalPageJSON[T any](m *Page[T]) ([]byte, error) { j := NewPageJSON(m) return json.Marshal(j) }"},
+	},
+}
+
+const embeddedIn = `package p
+type Base struct{ ID int }
+type User struct {
+	Base
+	UserName string
+}
+`
+
+const mixedTagsIn = `package p
+type User struct {
+	ID       int
+	UserName string 'json:"name"'
+	Email    string 'db:"email"'
+	cache    map[string]int
+}
+`
+
+const validateIn = `package p
+type User struct {
+	UserName string 'json:"name" validate:"required"'
+	Email    *string 'validate:"email,required"'
+	Bio      string 'validate:"max=200"'
+	Age      int 'validate:"required"'
+}
+`
+
+const metaIn = `package p
+type Meta struct {
+	CreatedAt int64
+	UpdatedAt int64 'json:",omitempty"'
+	Author    string 'json:"by"'
+	Secret    string 'json:"-"'
+}
+`
+
+const constraintIn = `package p
+type Number interface {
+	~int | ~int64 | ~float64
+}
+type User struct{ Name string }
+type Order struct{ Total float64 }
+`
+
+const genericFieldIn = `package p
+type List[T any] []T
+type User struct {
+	Items List[int]
+}
+`
+
+const typeCheckIn = `package p
+type Level int
+type Name = string
+type Stamp int64
+func (Stamp) MarshalJSON() ([]byte, error) { return []byte("0"), nil }
+type Handler func()
+type User struct {
+	Level   Level
+	Name    Name
+	Stamp   Stamp
+	OnEvent Handler 'json:"-"'
+}
+`
+
+const funcTypesIn = `package p
+type Handler func()
+func (Handler) MarshalJSON() ([]byte, error) { return []byte('"handler"'), nil }
+type Callback func()
+type User struct {
+	H     Handler
+	Plain Callback 'json:"-"'
+}
+`
+
+const dashIn = `package p
+type User struct {
+	Skip string 'json:"-"'
+	Dash string 'json:"-,"'
+	Name string
+}
+`
+
+const maxDepthIn = `package p
+type Address struct{ ZipCode string }
+type User struct {
+	Home   Address
+	Work   *Address
+	Homes  []Address
+	Grid   [][]Address
+	ByCity map[string]Address
+}
+`
+
+const namedCollectionsIn = `package p
+type TagList []string
+type Nodes []Node
+type Node struct {
+	Tags     TagList
+	Children Nodes
+	Next     *Node
+}
+`
+
+const optionalIn = `package p
+type Optional[T any] struct {
+	v   T
+	set bool
+}
+func Some[T any](v T) Optional[T] { return Optional[T]{v, true} }
+func (o Optional[T]) IsSet() bool { return o.set }
+func (o Optional[T]) Value() T    { return o.v }
+type User struct {
+	NickName Optional[string]
+	Age      Optional[int] 'json:",omitempty"'
+	Name     string
+}
+`
+
+const embeddedGeneratedIn = `package p
+type Base struct {
+	ID    int
+	Email string
+}
+type Outer struct {
+	Base
+	Name string
+	ID   string
+}
+`
+
+const anonymousStructIn = `package p
+type Address struct{ ZipCode string }
+type User struct {
+	Addr struct {
+		Street  string
+		ZipCode string 'json:"zip,omitempty" db:"zip"'
+		Geo     *struct{ Lat, Lng float64 }
+	}
+	Homes []struct{ Home Address }
+	Empty struct{}
+}
+`
+
+const groupedIn = `package p
+
+// Models of the API.
+type (
+	// A is the first.
+	A struct{ UserName string }
+	B struct {
+		Next *A
+	}
+	C int
+	D = A
+)
+
+// E stands alone.
+type E struct{ ID int }
+`
+
+const genericTypesIn = `package p
+type Number interface{ ~int | ~float64 }
+type Page[T any] struct {
+	Items    []T
+	First    *T
+	ByName   map[string]T
+	Meta     struct{ TotalCount int }
+	NextPage string 'validate:"required"'
+}
+type Pair[K comparable, V Number] struct {
+	Key      K
+	MaxValue V
+}
+`
+
+// collapse replaces each run of white space in s by a space.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func TestFeatures(t *testing.T) {
+	for _, test := range features {
+		t.Run(test.name, func(t *testing.T) {
+			files, types, output := test.files, test.types, test.output
+			if files == nil {
+				files = map[string]string{"p.go": userIn}
+			}
+			if types == "" {
+				types = "User"
+			}
+			if output == "" {
+				output = strings.ToLower(strings.Split(types, ",")[0]) + "_json.go"
+			}
+			dir, logs := generate(t, files, types, test.args...)
+			got := readFile(t, dir, output)
+			for _, want := range test.want {
+				if !strings.Contains(collapse(got), collapse(ticks(want))) {
+					t.Errorf("%s lacks %s:\n%s", output, ticks(want), got)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(collapse(got), collapse(ticks(notWant))) {
+					t.Errorf("%s has %s:\n%s", output, ticks(notWant), got)
+				}
+			}
+			for _, want := range test.logs {
+				if !strings.Contains(logs, want) {
+					t.Errorf("log lacks %q:\n%s", want, logs)
+				}
+			}
+			for _, notWant := range test.notLogs {
+				if strings.Contains(logs, notWant) {
+					t.Errorf("log has %q:\n%s", notWant, logs)
+				}
+			}
+			vet(t, dir)
+		})
+	}
+}
+
+// TestInsertField checks that inserting a field in the middle of a struct
+// only adds the lines of that field to the output. The field is no wider
+// than the others, so that gofmt does not realign the lines around it.
+func TestInsertField(t *testing.T) {
+	const before = `package p
+type User struct {
+	ID       int    'json:"id" db:"id"'
+	UserName string 'yaml:"n" json:"name"'
+	Email    string 'db:"email"'
+}
+`
+	dir, _ := generate(t, map[string]string{"p.go": before}, "User")
+	old := readFile(t, dir, "user_json.go")
+	writeFiles(t, dir, map[string]string{"p.go": strings.Replace(before, "\tEmail", "\tNick     string 'db:\"nick\"'\n\tEmail", 1)})
+	generateIn(t, dir, "User")
+	var kept []string
+	added := 0
+	for _, line := range strings.SplitAfter(readFile(t, dir, "user_json.go"), "\n") {
+		if strings.Contains(line, "Nick") {
+			added++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if added != 3 {
+		t.Errorf("%d lines of the inserted field, want 3", added)
+	}
+	if got := strings.Join(kept, ""); got != old {
+		t.Errorf("inserting a field changes other lines:\n%s\nwas\n%s", got, old)
+	}
+}
+
+// A run is a package whose tests, in package p, use the code generated
+// for it, checking how it behaves.
+type run struct {
+	name  string
+	types string // default User
+	args  []string
+	files map[string]string // the types and their tests
+}
+
+var runs = []run{
+	{
+		name: "deref-pointers",
+		args: []string{"-deref-pointers"},
+		files: map[string]string{"p.go": `package p
+type User struct {
+	Name *string
+	Age  *int
+	Tags *[]string
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestNil(t *testing.T) {
+	b, err := json.Marshal(User{})
+	if err != nil || string(b) != '{"name":"","age":0,"tags":null}' {
+		t.Fatalf("%s, %v", b, err)
+	}
+	u := NewUserJSON(&User{}).ToUser()
+	if u.Name == nil || *u.Name != "" || u.Age == nil || *u.Age != 0 {
+		t.Errorf("%+v", u)
+	}
+}
+func TestNonNil(t *testing.T) {
+	name, age := "gopher", 13
+	b, err := json.Marshal(User{Name: &name, Age: &age, Tags: &[]string{"a"}})
+	if err != nil || string(b) != '{"name":"gopher","age":13,"tags":["a"]}' {
+		t.Fatalf("%s, %v", b, err)
+	}
+	u := NewUserJSON(&User{Name: &name, Age: &age}).ToUser()
+	if *u.Name != name || *u.Age != age || u.Name == &name {
+		t.Errorf("%+v", u)
+	}
+}
+`},
+	},
+	{
+		// Fields are accessed through selectors, so fields named like the
+		// locals of the generated functions are copied like any other.
+		name:  "fields named like the locals",
+		types: "User,Address",
+		args:  []string{"-nested"},
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	v    int
+	m    string
+	j    *Address
+	Home Address
+}
+`, "p_test.go": `package p
+import "testing"
+func TestCopy(t *testing.T) {
+	u := User{v: 1, m: "m", j: &Address{City: "j"}, Home: Address{City: "home"}}
+	c := NewUserJSON(&u)
+	if c.v != 1 || c.m != "m" || c.j.City != "j" || c.Home.City != "home" {
+		t.Fatalf("%+v", c)
+	}
+	if back := c.ToUser(); back.v != 1 || back.m != "m" || back.j.City != "j" || back.j == u.j || back.Home != u.Home {
+		t.Errorf("%+v", back)
+	}
+}
+`},
+	},
+	{
+		// Values beyond -max-depth are marshalled by the MarshalJSON of
+		// their type, so the keys are the same.
+		name:  "max-depth",
+		types: "User,Address",
+		args:  []string{"-nested", "-max-depth=1"},
+		files: map[string]string{"p.go": maxDepthIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	a := Address{ZipCode: "1"}
+	u := User{Home: a, Work: &a, Homes: []Address{a}, Grid: [][]Address{{a}}, ByCity: map[string]Address{"c": a}}
+	b, err := json.Marshal(u)
+	if want := '{"home":{"zip_code":"1"},"work":{"zip_code":"1"},"homes":[{"zip_code":"1"}],"grid":[[{"zip_code":"1"}]],"by_city":{"c":{"zip_code":"1"}}}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if back := NewUserJSON(&u).ToUser(); back.Homes[0] != a || *back.Work != a || back.Work == u.Work {
+		t.Errorf("%+v", back)
+	}
+}
+`},
+	},
+	{
+		name:  "named collection types",
+		types: "Node",
+		args:  []string{"-nested"},
+		files: map[string]string{"p.go": namedCollectionsIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	n := Node{Tags: TagList{"a", "b"}, Children: Nodes{{Tags: TagList{"c"}}}, Next: &Node{}}
+	b, err := json.Marshal(n)
+	if want := '{"tags":["a","b"],"children":[{"tags":["c"],"children":null,"next":null}],"next":{"tags":null,"children":null,"next":null}}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if back := NewNodeJSON(&n).ToNode(); !reflect.DeepEqual(back, n) {
+		t.Errorf("%+v, want %+v", back, n)
+	}
+}
+`},
+	},
+	{
+		name:  "null-type",
+		types: "User",
+		args:  []string{"-null-type=Optional"},
+		files: map[string]string{"p.go": optionalIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	for _, tt := range []struct {
+		u    User
+		want string
+	}{
+		{User{Name: "a"}, '{"nick_name":null,"name":"a"}'},
+		{User{NickName: Some(""), Age: Some(0)}, '{"nick_name":"","age":0,"name":""}'},
+		{User{NickName: Some("b"), Age: Some(3)}, '{"nick_name":"b","age":3,"name":""}'},
+	} {
+		if b, err := json.Marshal(tt.u); err != nil || string(b) != tt.want {
+			t.Errorf("%+v: %s, %v, want %s", tt.u, b, err, tt.want)
+		}
+	}
+}
+`},
+	},
+	{
+		name:  "null-type without type parameters",
+		types: "User",
+		args:  []string{"-null-type=Maybe"},
+		files: map[string]string{"p.go": `package p
+type Maybe struct {
+	s  string
+	ok bool
+}
+func (m Maybe) IsSet() bool   { return m.ok }
+func (m Maybe) Value() string { return m.s }
+type User struct{ Alias Maybe }
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	for u, want := range map[User]string{
+		{}:                  '{"alias":null}',
+		{Maybe{"", true}}:   '{"alias":""}',
+		{Maybe{"x", true}}:  '{"alias":"x"}',
+		{Maybe{"x", false}}: '{"alias":null}',
+	} {
+		if b, err := json.Marshal(u); err != nil || string(b) != want {
+			t.Errorf("%+v: %s, %v, want %s", u, b, err, want)
+		}
+	}
+}
+`},
+	},
+	{
+		name:  "flatten-embedded",
+		types: "Outer,Base",
+		args:  []string{"-flatten-embedded"},
+		files: map[string]string{"p.go": embeddedGeneratedIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	o := Outer{Base: Base{ID: 1, Email: "a@b.c"}, Name: "n", ID: "o1"}
+	b, err := json.Marshal(o)
+	if want := '{"name":"n","id":"o1","email":"a@b.c"}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if back := NewOuterJSON(&o).ToOuter(); back.Email != o.Email || back.ID != o.ID || back.Name != o.Name {
+		t.Errorf("%+v", back)
+	}
+}
+`},
+	},
+	{
+		name:  "value-constructor",
+		types: "User,Address",
+		args:  []string{"-nested", "-value-constructor"},
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	Name string
+	Home *Address
+	Past []Address
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+var sink UserJSON
+func TestMarshal(t *testing.T) {
+	u := User{Name: "a", Home: &Address{City: "b"}, Past: []Address{{City: "c"}}}
+	b, err := json.Marshal(u)
+	if want := '{"name":"a","home":{"city":"b"},"past":[{"city":"c"}]}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	flat := User{Name: "a"}
+	if n := testing.AllocsPerRun(100, func() { sink = NewUserJSON(&flat) }); n != 0 {
+		t.Errorf("NewUserJSON allocates %v times", n)
+	}
+}
+func BenchmarkNewUserJSON(b *testing.B) {
+	u := User{Name: "a"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sink = NewUserJSON(&u)
+	}
+}
+`},
+	},
+	{
+		// The generated structs encoded come from their pools, so that
+		// only the result is allocated.
+		name:  "buffer-pool",
+		types: "User,Address",
+		args:  []string{"-buffer-pool", "-nested", "-value-constructor"},
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	Name  string
+	Home  Address
+	Past  []Address
+	Score float64
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+var sink []byte
+func TestMarshal(t *testing.T) {
+	u := User{Name: "a", Home: Address{City: "b"}, Past: []Address{{City: "c"}}, Score: 1.5}
+	for i := 0; i < 2; i++ {
+		b, err := json.Marshal(u)
+		if want := '{"name":"a","home":{"city":"b"},"past":[{"city":"c"}],"score":1.5}'; err != nil || string(b) != want {
+			t.Errorf("%s, %v, want %s", b, err, want)
+		}
+	}
+	flat := User{Name: "a", Score: 1.5}
+	pooled := testing.AllocsPerRun(100, func() { sink, _ = flat.MarshalJSON() })
+	plain := testing.AllocsPerRun(100, func() {
+		j := NewUserJSON(&flat)
+		sink, _ = json.Marshal(&j)
+	})
+	if pooled >= plain {
+		t.Errorf("MarshalJSON allocates %v times, json.Marshal %v times", pooled, plain)
+	}
+}
+`},
+	},
+	{
+		name:  "gen-slice-helper",
+		types: "User",
+		args:  []string{"-gen-slice-helper"},
+		files: map[string]string{"p.go": `package p
+type User struct{ UserID int }
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestSlice(t *testing.T) {
+	b, err := json.Marshal(NewUserJSONSlice([]User{{UserID: 1}, {UserID: 2}}))
+	if want := '[{"user_id":1},{"user_id":2}]'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if js := NewUserJSONSlice(nil); js != nil {
+		t.Errorf("NewUserJSONSlice(nil) = %v, want nil", js)
+	}
+}
+`},
+	},
+	{
+		name:  "anonymous struct fields",
+		types: "User,Address",
+		args:  []string{"-nested"},
+		files: map[string]string{"p.go": anonymousStructIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	var u User
+	u.Addr.Street = "Main St"
+	u.Addr.Geo = &struct{ Lat, Lng float64 }{1, 2}
+	u.Homes = []struct{ Home Address }{{Address{"1"}}}
+	b, err := json.Marshal(u)
+	if want := '{"addr":{"street":"Main St","geo":{"lat":1,"lng":2}},"homes":[{"home":{"zip_code":"1"}}],"empty":{}}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if back := NewUserJSON(&u).ToUser(); !reflect.DeepEqual(back, u) || back.Addr.Geo == u.Addr.Geo {
+		t.Errorf("%+v, want %+v", back, u)
+	}
+}
+`},
+	},
+	{
+		name:  "generic types",
+		types: "Page,Pair",
+		args:  []string{"-nested"},
+		files: map[string]string{"p.go": genericTypesIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	one := 1
+	p := Page[int]{Items: []int{1, 2}, First: &one, ByName: map[string]int{"a": 1}, NextPage: "2"}
+	p.Meta.TotalCount = 2
+	b, err := json.Marshal(p)
+	if want := '{"items":[1,2],"first":1,"by_name":{"a":1},"meta":{"total_count":2},"next_page":"2"}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+	if back := NewPageJSON(&p).ToPage(); !reflect.DeepEqual(back, p) {
+		t.Errorf("%+v, want %+v", back, p)
+	}
+	b, err = json.Marshal(Pair[string, float64]{Key: "k", MaxValue: 1.5})
+	if want := '{"key":"k","max_value":1.5}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+}
+`},
+	},
+	{
+		name:  "gen-fixture",
+		types: "User,Address",
+		args:  []string{"-gen-fixture", "-nested"},
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	UserID  int64
+	Name    string
+	Active  bool
+	Score   float64
+	Tags    []string
+	Attrs   map[string]int
+	Home    Address
+	Friends []Address
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+func TestFixture(t *testing.T) {
+	u := NewUserFixture()
+	b, err := json.Marshal(u)
+	if want := '{"user_id":1,"name":"Name","active":true,"score":1,"tags":[],"attrs":{},"home":{"city":"City"},"friends":[]}'; err != nil || string(b) != want {
+		t.Fatalf("%s, %v, want %s", b, err, want)
+	}
+	var j UserJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if back := j.ToUser(); !reflect.DeepEqual(back, u) {
+		t.Errorf("%+v, want %+v", back, u)
+	}
+}
+`},
+	},
+	{
+		name: "named func types",
+		files: map[string]string{"p.go": funcTypesIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	b, err := json.Marshal(User{H: func() {}, Plain: func() {}})
+	if err != nil || string(b) != '{"h":"handler"}' {
+		t.Errorf("%s, %v", b, err)
+	}
+	if u := NewUserJSON(&User{H: func() {}}).ToUser(); u.H == nil {
+		t.Errorf("%+v", u)
+	}
+}
+`},
+	},
+	{
+		name: "dash tags",
+		args: []string{"-omitempty"},
+		files: map[string]string{"p.go": dashIn, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	b, err := json.Marshal(User{Skip: "s", Dash: "d", Name: "n"})
+	if err != nil || string(b) != '{"-":"d","name":"n"}' {
+		t.Errorf("%s, %v", b, err)
+	}
+	b, err = json.Marshal(User{Skip: "s"})
+	if err != nil || string(b) != '{}' {
+		t.Errorf("%s, %v", b, err)
+	}
+}
+`},
+	},
+	{
+		name:  "sort-keys",
+		types: "User,Address",
+		args:  []string{"-sort-keys", "-nested"},
+		files: map[string]string{"p.go": `package p
+type Address struct {
+	ZipCode string
+	City    string
+}
+type User struct {
+	UserName string
+	Home     Address
+	ID       int64
+	Attrs    map[string]int
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	u := User{UserName: "gopher", Home: Address{ZipCode: "1", City: "c"}, ID: 1<<62 + 1, Attrs: map[string]int{"b": 2, "a": 1}}
+	b, err := json.Marshal(u)
+	if want := '{"attrs":{"a":1,"b":2},"home":{"city":"c","zip_code":"1"},"id":4611686018427387905,"user_name":"gopher"}'; err != nil || string(b) != want {
+		t.Errorf("%s, %v, want %s", b, err, want)
+	}
+}
+`},
+	},
+	{
+		name: "gen-writer",
+		args: []string{"-gen-writer"},
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserName string
+	Tags     []string
+}
+`, "p_test.go": `package p
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	for _, u := range []User{{UserName: "gopher", Tags: []string{"a"}}, {}} {
+		if err := u.WriteJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := '{"user_name":"gopher","tags":["a"]}' + "\n" + '{"user_name":"","tags":null}' + "\n"
+	if buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+	d := json.NewDecoder(&buf)
+	for d.More() {
+		var v map[string]interface{}
+		if err := d.Decode(&v); err != nil {
+			t.Error(err)
+		}
+	}
+}
+`},
+	},
+	{
+		name: "gen-partial",
+		args: []string{"-gen-partial"},
+		files: map[string]string{"p.go": `package p
+type User struct {
+	UserID   int
+	UserName string
+	Email    string
+	Tags     []string
+}
+`, "p_test.go": `package p
+import "testing"
+func TestMarshalJSONFields(t *testing.T) {
+	u := User{UserID: 1, UserName: "gopher", Email: "gopher@example.com", Tags: []string{"a"}}
+	for _, tt := range []struct {
+		fields map[string]bool
+		want   string
+	}{
+		{map[string]bool{"user_name": true, "tags": true}, '{"tags":["a"],"user_name":"gopher"}'},
+		{map[string]bool{"user_id": true, "UserName": true, "missing": true}, '{"user_id":1}'},
+		{nil, '{}'},
+	} {
+		b, err := u.MarshalJSONFields(tt.fields)
+		if err != nil || string(b) != tt.want {
+			t.Errorf("%v: %s, %v, want %s", tt.fields, b, err, tt.want)
+		}
+	}
+}
+`},
+	},
+	{
+		// Fields are only referred to through selectors, so a field named
+		// json doesn't shadow the package. A type named json renames the
+		// import.
+		name: "fields named like the json package",
+		args: []string{"-gen-partial", "-gen-writer", "-gen-validate", "-sort-keys"},
+		files: map[string]string{"p.go": `package p
+type json struct{ raw string }
+type User struct {
+	Name string 'validate:"required"'
+	json json
+	JSON string
+}
+`, "p_test.go": `package p
+import (
+	stdjson "encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	u := User{Name: "gopher", json: json{"raw"}, JSON: "j"}
+	b, err := stdjson.Marshal(u)
+	if err != nil || string(b) != '{"json":"j","name":"gopher"}' {
+		t.Errorf("%s, %v", b, err)
+	}
+	if back := NewUserJSON(&u).ToUser(); back != u {
+		t.Errorf("%+v", back)
+	}
+	if err := u.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+`},
+	},
+	{
+		name:  "with-context",
+		types: "User,Address",
+		args:  []string{"-nested", "-with-context"},
+		files: map[string]string{"p.go": `package p
+type Address struct{ City string }
+type User struct {
+	Name string
+	Home Address
+	Work *Address
+}
+`, "p_test.go": `package p
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+func TestNew(t *testing.T) {
+	u := User{Name: "gopher", Home: Address{City: "home"}, Work: &Address{City: "work"}}
+	j := NewUserJSON(context.Background(), &u)
+	if j.Home.City != "home" || j.Work.City != "work" {
+		t.Errorf("%+v", j)
+	}
+	b, err := json.Marshal(u)
+	if err != nil || string(b) != '{"name":"gopher","home":{"city":"home"},"work":{"city":"work"}}' {
+		t.Errorf("%s, %v", b, err)
+	}
+}
+`},
+	},
+	{
+		name: "type-map",
+		args: []string{"-type-map=decimal.Decimal=string"},
+		files: map[string]string{"decimal/decimal.go": `package decimal
+type Decimal struct{ s string }
+func New(s string) Decimal { return Decimal{s} }
+func (d Decimal) String() string { return d.s }
+`, "p.go": `package p
+import "example.com/p/decimal"
+type User struct {
+	Name  string
+	Price decimal.Decimal
+}
+`, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+	"example.com/p/decimal"
+)
+func TestMarshal(t *testing.T) {
+	b, err := json.Marshal(User{Name: "gopher", Price: decimal.New("1.50")})
+	if err != nil || string(b) != '{"name":"gopher","price":"1.50"}' {
+		t.Errorf("%s, %v", b, err)
+	}
+}
+`},
+	},
+	{
+		// The template of the README.
+		name: "template",
+		args: []string{"-template=snake.tmpl"},
+		files: map[string]string{"p.go": userIn, "snake.tmpl": snakeTemplate, "p_test.go": `package p
+import (
+	"encoding/json"
+	"testing"
+)
+func TestMarshal(t *testing.T) {
+	b, err := json.Marshal(User{ID: 1, UserName: "gopher"})
+	if err != nil || string(b) != '{"id":1,"name":"gopher","email":null}' {
+		t.Errorf("%s, %v", b, err)
+	}
+}
+`},
+	},
+}
+
+const snakeTemplate = `{{range .Types}}
+type {{.Name}}{{$.Suffix}} struct {
+{{- range .Fields}}
+	{{if not .Embedded}}{{.Name}} {{end}}{{.ShadowType}} {{.Tag}}
+{{- end}}
+}
+
+func (m {{.Name}}) MarshalJSON() ([]byte, error) {
+	return {{import "encoding/json"}}.Marshal({{.Name}}{{$.Suffix}}(m))
+}
+{{end}}
+`
+
+func TestRuns(t *testing.T) {
+	for _, test := range runs {
+		t.Run(test.name, func(t *testing.T) {
+			types := test.types
+			if types == "" {
+				types = "User"
+			}
+			dir, _ := generate(t, test.files, types, test.args...)
+			goTest(t, dir)
+		})
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	tmpl := filepath.Join(t.TempDir(), "default.tmpl")
+	if err := ioutil.WriteFile(tmpl, []byte(defaultTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		input  string
+		types  string
+		args   []string
+		output string
+	}{
+		{basicIn, "User", nil, "user_json.go"},
+		{genericTypesIn, "Pair", nil, "pair_json.go"},
+		{userIn, "User", []string{"-tag=yaml", "-also-tag=toml", "-omitempty"}, "user_yaml.go"},
+		{userIn, "User", []string{"-test"}, "user_json_test.go"},
+	} {
+		files := map[string]string{"p.go": tt.input}
+		dir, _ := generate(t, files, tt.types, tt.args...)
+		builtin := readFile(t, dir, tt.output)
+		dir, _ = generate(t, files, tt.types, append(tt.args, "-template="+tmpl)...)
+		got := readFile(t, dir, tt.output)
+		// Only the headers, quoting the arguments, differ.
+		builtin, got = builtin[strings.Index(builtin, "\n"):], got[strings.Index(got, "\n"):]
+		if got != builtin {
+			t.Errorf("-type=%s %s: the default template gives\n%s\nthe built-in output is\n%s", tt.types, strings.Join(tt.args, " "), got, builtin)
+		}
+		vet(t, dir)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go": userIn + "\ntype Plain struct {\n\tName string 'json:\"name\"'\n}\n",
+		"keys.tmpl": `{{range .Types}}
+// {{.Name}}Keys lists the {{$.Tag}} keys of {{.Name}}.
+var {{.Name}}Keys = []string{ {{- range .Fields}}"{{.Key}}", {{end -}} }
+{{end}}`,
+	})
+	logs := generateIn(t, dir, "User,Plain", "-template="+filepath.Join(dir, "keys.tmpl"), "-skip-noop", "-warn-fields=3")
+	got := readFile(t, dir, "user_json.go")
+	if want := `var UserKeys = []string{"id", "name", "email", "age"}`; !strings.Contains(got, want) {
+		t.Errorf("output lacks %s:\n%s", want, got)
+	}
+	if strings.Contains(got, "PlainKeys") {
+		t.Errorf("-skip-noop did not skip Plain:\n%s", got)
+	}
+	if want := "User has 4 fields, more than -warn-fields=3"; !strings.Contains(logs, want) {
+		t.Errorf("log lacks %q:\n%s", want, logs)
+	}
+	vet(t, dir)
+
+	for _, option := range []string{"-gen-validate", "-gen-fixture", "-gen-writer", "-gen-partial", "-gen-slice-helper", "-buffer-pool", "-indent=\t", "-sort-keys", "-value-constructor", "-with-context"} {
+		code, _, stderr := runMain(t, dir, "-type=User", "-template=keys.tmpl", option)
+		name := strings.SplitN(option, "=", 2)[0]
+		if want := "-template cannot be combined with " + name; code != exitUsage || !strings.Contains(stderr, want) {
+			t.Errorf("-template with %s: exit code %d, want %d, logging\n%s", option, code, exitUsage, stderr)
+		}
+	}
+
+	code, stdout, _ := runMain(t, dir, "-print-template")
+	if code != 0 || stdout != defaultTemplate {
+		t.Errorf("-print-template: exit code %d, printing\n%s", code, stdout)
+	}
+}
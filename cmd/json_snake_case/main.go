@@ -6,30 +6,110 @@ import (
 	"fmt"
 	"go/ast"
 	"go/build"
+	"go/build/constraint"
 	"go/format"
 	"go/parser"
 	"go/token"
 	"go/types"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
-var tagRegex = regexp.MustCompile(`([0-9a-zA-Z,_=&\(\)\-]+)(:( )?"([0-9a-zA-Z,_=&\(\)\-]*)")?`)
-
 var (
-	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
-	output    = flag.String("output", "", "output file name; default srcdir/<type>_json.go")
+	typeNames              = flag.String("type", "", "comma-separated list of type names, or * for all struct types; must be set")
+	output                 = flag.String("output", "", "output file name, relative to the current directory; default srcdir/<type>_json.go")
+	test                   = flag.Bool("test", false, "generate test-only helpers, including Marshal<Type>JSON, into srcdir/<type>_json_test.go")
+	tag                    = flag.String("tag", "json", "struct tag key to generate, e.g. json or yaml; further comma-separated keys are added as with -also-tag")
+	indent                 = flag.String("indent", "", "indent string for the generated MarshalJSON; default compact output")
+	excludeTag             = flag.String("exclude-tag", "", "comma-separated list of tag keys to drop from the generated struct")
+	buildTag               = flag.String("build-tag", "", "build constraint expression for the generated file, e.g. gen")
+	variant                = flag.String("variant", "", "write srcdir/<type>_json_<variant>.go constrained by //go:build <variant>")
+	verbose                = flag.Bool("v", false, "log diagnostics about the generated fields")
+	schema                 = flag.Bool("schema", false, "also write a JSON Schema of the types next to the output, e.g. user_json.schema.json")
+	bufferPool             = flag.Bool("buffer-pool", false, "make MarshalJSON reuse pooled buffers and encoders to reduce allocations")
+	stripFieldPrefix       = flag.String("strip-field-prefix", "", "remove this prefix from field names before converting them to keys")
+	keyPrefix              = flag.String("key-prefix", "", "prefix joined with an underscore to every generated key")
+	forceRename            = flag.Bool("force-rename", false, "also apply -key-prefix to names given explicitly in source tags")
+	genValidate            = flag.Bool("gen-validate", false, "generate a Validate method checking validate:\"required\" string and pointer fields")
+	inlineRegion           = flag.Bool("inline-region", false, "replace the lines between // json_snake:begin and // json_snake:end in the output file instead of overwriting it")
+	nested                 = flag.Bool("nested", false, "convert fields whose types are also generated to their generated struct types")
+	maxDepth               = flag.Int("max-depth", 0, "with -nested, convert values of generated types only within this many levels of slices, arrays, maps and anonymous structs of a field type; 0 for no limit")
+	outputMode             = flag.String("output-mode", "overwrite", "what to do with an existing output file: overwrite, append, merge or skip-existing")
+	quiet                  = flag.Bool("quiet", false, "do not log warnings and the summary of the generated types; errors are still logged")
+	derefPointers          = flag.Bool("deref-pointers", false, "generate pointer fields as the type pointed to, using the zero value for nil")
+	alsoTag                = flag.String("also-tag", "", "comma-separated list of further tag keys to generate with the same snake case name")
+	noEditCheck            = flag.Bool("no-edit-check", false, "overwrite an output file lacking the generated code header without warning")
+	style                  = flag.String("style", "snake", "how field names become keys: snake, camelPreserveInitialisms, camelLower or proto")
+	templateFile           = flag.String("template", "", "text/template file rendering the generated declarations")
+	printTemplate          = flag.Bool("print-template", false, "print the embedded default template, which renders the built-in output, as a starting point for a custom -template, and exit")
+	sourcePosition         = flag.Bool("source-pos", false, "mention the file and line declaring each type in the generated comments")
+	sinceGoVersion         = flag.String("since-go-version", "", "oldest Go release, e.g. 1.17, the output must build with; newer requirements get a build constraint")
+	skipNoop               = flag.Bool("skip-noop", false, "skip types whose source tags already match the generated ones")
+	typeCheck              = flag.Bool("type-check", false, "type-check the package to resolve named and aliased field types")
+	snakeNumbers           = flag.String("snake-numbers", "grouped", "digits in snake case keys: grouped, as in address2, or separated, as in address_2")
+	strict                 = flag.Bool("strict", false, "exit with an error if the generated code is not valid Go")
+	typeMap                = flag.String("type-map", "", "comma-separated SourceType=GeneratedType pairs, e.g. decimal.Decimal=string, converted with String")
+	withContext            = flag.Bool("with-context", false, "make the constructors take a context.Context, passed on to nested constructors")
+	genPartial             = flag.Bool("gen-partial", false, "generate MarshalJSONFields, marshalling only the requested keys")
+	sortKeys               = flag.Bool("sort-keys", false, "make MarshalJSON emit object keys in sorted order")
+	excludeFiles           = flag.String("exclude-files", "", "comma-separated glob patterns of file names to ignore in the package directory")
+	genWriter              = flag.Bool("gen-writer", false, "generate WriteJSON, encoding the value to an io.Writer")
+	printOnly              = flag.Bool("print", false, "print the generated code with line numbers to standard error instead of writing files")
+	genFixture             = flag.Bool("gen-fixture", false, "generate New<Type>Fixture, returning a value with sample field values")
+	group                  = flag.Bool("group", false, "generate the types sorted by name, after a comment listing them")
+	recursive              = flag.Bool("recursive", false, "also generate for the packages in the subdirectories of the directory, as does a ./... argument")
+	nullType               = flag.String("null-type", "", "wrapper type, e.g. Optional, whose fields are generated as null unless IsSet returns true, and as Value otherwise")
+	requireTag             = flag.String("require-tag", "", "only generate fields whose tag has the given key:value, e.g. api:public")
+	flattenEmbedded        = flag.Bool("flatten-embedded", false, "generate the fields of embedded types generated in the same run in place of the embedded type")
+	valueConstructor       = flag.Bool("value-constructor", false, "make New<Type>JSON return the generated struct by value rather than a pointer")
+	ignoreBuildConstraints = flag.Bool("ignore-build-constraints", false, "also read the package files excluded by build constraints or file names")
+	planJSON               = flag.Bool("plan-json", false, "print a JSON description of the types and fields that would be generated to standard output instead of writing files")
+	packageDoc             = flag.String("package-doc", "", "text of a package comment \"// Package <name> <text>\" for the output of a package whose files have none")
+	warnFields             = flag.Int("warn-fields", 0, "warn about generated types with more fields than this; 0 disables the warning")
+	outputSuffix           = flag.String("output-suffix", "", "suffix of the default output file name before .go, e.g. .gen or _generated; default _<tag>")
+	checkOutput            = flag.Bool("check-output", false, "type-check the package with the generated code before writing it, exiting with an error on type errors")
+	nameCmd                = flag.String("name-cmd", "", "command reading a field name on standard input and writing its key to standard output, used instead of -style")
+	initialismsFile        = flag.String("initialisms-file", "", "file listing initialisms such as ID or HTTP, one per line, kept in one word when splitting field names")
+	initialismsMode        = flag.String("initialisms-mode", "merge", "whether -initialisms-file is merged with the built-in initialisms or replaces them: merge or replace")
+	mkdir                  = flag.Bool("mkdir", false, "create the directory of the output file if it does not exist")
+	genSliceHelper         = flag.Bool("gen-slice-helper", false, "generate New<Type>JSONSlice, converting a slice of values with New<Type>JSON")
+	config                 = flag.String("config", "", "JSON file setting any of these flags; command-line flags take precedence")
+	onlyTagged             boolOrString
+	omitEmpty              boolOrString
+	omitZero               boolOrString
 )
 
+func init() {
+	flag.Var(&onlyTagged, "only-tagged", "only generate fields that carry a tag; -only-tagged=key checks for key instead of -tag")
+	flag.Var(&omitEmpty, "omitempty", "add the omitempty option to every field; -omitempty=T,U only does so for the listed types")
+	flag.Var(&omitZero, "omitzero", "add the omitzero option of Go 1.24 to every field; -omitzero=T,U only does so for the listed types")
+}
+
 // Usage is a replacement usage function for the flags package.
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\t%s [flags] -type T [directory]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Examples:\n")
+	fmt.Fprintf(os.Stderr, "\t%s -type=User\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\t\tgenerate user_json.go for User in the current directory\n")
+	fmt.Fprintf(os.Stderr, "\t%s -type=User,Order ./models\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\t\tgenerate models/user_json.go for User and Order\n")
+	fmt.Fprintf(os.Stderr, "\t%s -type=User -output=models_json.go ./models\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "\t\twrite the generated code to models_json.go\n")
 }
 
 func main() {
@@ -37,10 +117,20 @@ func main() {
 	log.SetPrefix("json_snake: ")
 	flag.Usage = Usage
 	flag.Parse()
+	if *printTemplate {
+		fmt.Print(defaultTemplate)
+		return
+	}
+	if *config != "" {
+		if err := loadConfig(*config); err != nil {
+			exitf(exitUsage, "reading config: %s", err)
+		}
+	}
 	if len(*typeNames) == 0 {
 		flag.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
+	checkFlags()
 	types := strings.Split(*typeNames, ",")
 	// We accept either one directory or a list of files. Which do we have?
 	args := flag.Args()
@@ -49,44 +139,309 @@ func main() {
 		args = []string{"."}
 	}
 
-	g := &Generator{}
-	g.pkg = &Package{}
-	if len(args) == 1 && isDirectory(args[0]) {
-		dir := args[0]
-		p, err := build.Default.ImportDir(dir, 0)
-		if err != nil {
-			log.Fatalf("cannot process directory %s: %s", dir, err)
+	root := strings.TrimSuffix(args[0], "/...")
+	recursing := *recursive || root != args[0]
+	if root == "" {
+		root = "/"
+	}
+	if len(args) != 1 || !isDirectory(root) {
+		// TODO: add support files
+		exitf(exitUsage, "not supported files")
+	}
+	if !recursing {
+		generatePackage(root, types, nil)
+		return
+	}
+	if *output != "" {
+		exitf(exitUsage, "-output cannot be combined with -recursive: each package gets its own output file")
+	}
+	found := make(map[string]bool)
+	for _, dir := range packageDirs(root) {
+		generatePackage(dir, types, found)
+	}
+	var notFound []string
+	for _, name := range types {
+		if name != "*" && !found[name] {
+			notFound = append(notFound, name)
 		}
-		g.pkg.dir = dir
-		g.pkg.name = p.Name
+	}
+	if len(notFound) == 1 {
+		exitf(exitNotFound, "type %s not found in any package of %s", notFound[0], root)
+	}
+	if len(notFound) > 1 {
+		exitf(exitNotFound, "types %s not found in any package of %s", strings.Join(notFound, ", "), root)
+	}
+}
 
-		// TODO: support only gofile
-		files := make([]File, len(p.GoFiles))
-		for i, v := range p.GoFiles {
-			files[i] = File{
-				Name: prefixDirectory(g.pkg.dir, v),
+// checkFlags validates the flags, exiting with exitUsage on invalid ones,
+// and derives the settings they imply, such as -also-tag from
+// -tag=json,yaml.
+func checkFlags() {
+	if keys := strings.Split(*tag, ","); len(keys) > 1 {
+		// -tag=json,yaml is short for -tag=json -also-tag=yaml.
+		*tag = strings.TrimSpace(keys[0])
+		if *alsoTag != "" {
+			keys = append(keys, *alsoTag)
+		}
+		*alsoTag = strings.Join(keys[1:], ",")
+	}
+	if !isTagKey(*tag) {
+		exitf(exitUsage, "invalid -tag %q: must be a struct tag key such as json or yaml", *tag)
+	}
+	switch *snakeNumbers {
+	case "grouped", "separated":
+	default:
+		exitf(exitUsage, "invalid -snake-numbers %q: must be grouped or separated", *snakeNumbers)
+	}
+	if *maxDepth < 0 {
+		exitf(exitUsage, "invalid -max-depth %d: must not be negative", *maxDepth)
+	}
+	if err := parseTypeMap(*typeMap); err != nil {
+		exitf(exitUsage, "invalid -type-map %q: %s", *typeMap, err)
+	}
+	if key, value := splitRequireTag(); *requireTag != "" && (!isTagKey(key) || value == "") {
+		exitf(exitUsage, "invalid -require-tag %q: must be key:value, e.g. api:public", *requireTag)
+	}
+	if styles[*style] == nil {
+		exitf(exitUsage, "invalid -style %q: must be snake, camelPreserveInitialisms, camelLower or proto", *style)
+	}
+	for _, key := range alsoTags() {
+		if !isTagKey(key) || key == *tag {
+			exitf(exitUsage, "invalid -also-tag %q: must list struct tag keys other than %s", *alsoTag, *tag)
+		}
+	}
+	if strings.TrimLeft(*indent, " \t") != "" {
+		exitf(exitUsage, "invalid -indent %q: must contain only spaces and tabs", *indent)
+	}
+	if *nameCmd != "" {
+		if len(strings.Fields(*nameCmd)) == 0 {
+			exitf(exitUsage, "invalid -name-cmd %q: must name a command", *nameCmd)
+		}
+		if _, err := exec.LookPath(strings.Fields(*nameCmd)[0]); err != nil {
+			exitf(exitUsage, "invalid -name-cmd %q: %s", *nameCmd, err)
+		}
+	}
+	if *initialismsMode != "merge" && *initialismsMode != "replace" {
+		exitf(exitUsage, "invalid -initialisms-mode %q: must be merge or replace", *initialismsMode)
+	}
+	if *initialismsFile != "" {
+		if err := loadInitialisms(*initialismsFile, *initialismsMode == "replace"); err != nil {
+			exitf(exitUsage, "reading -initialisms-file: %s", err)
+		}
+	}
+	if *warnFields < 0 {
+		exitf(exitUsage, "invalid -warn-fields %d: must not be negative", *warnFields)
+	}
+	switch *outputMode {
+	case "overwrite", "append", "merge", "skip-existing":
+	default:
+		exitf(exitUsage, "invalid -output-mode %q: must be overwrite, append, merge or skip-existing", *outputMode)
+	}
+	if *inlineRegion && (*outputMode == "append" || *outputMode == "merge") {
+		exitf(exitUsage, "-inline-region cannot be combined with -output-mode=%s", *outputMode)
+	}
+	if *group && (*inlineRegion || *outputMode == "append" || *outputMode == "merge") {
+		exitf(exitUsage, "-group cannot be combined with -inline-region, -output-mode=append or -output-mode=merge")
+	}
+	if *packageDoc != "" && (*inlineRegion || *outputMode == "append" || *outputMode == "merge") {
+		exitf(exitUsage, "-package-doc cannot be combined with -inline-region, -output-mode=append or -output-mode=merge")
+	}
+	if *templateFile != "" && *outputMode == "merge" {
+		exitf(exitUsage, "-template cannot be combined with -output-mode=merge")
+	}
+	if *templateFile != "" {
+		// The template renders the methods in place of these options.
+		for _, name := range []string{"gen-validate", "gen-fixture", "gen-writer", "gen-partial", "gen-slice-helper", "buffer-pool", "indent", "sort-keys", "value-constructor", "with-context"} {
+			if f := flag.Lookup(name); f.Value.String() != f.DefValue {
+				exitf(exitUsage, "-template cannot be combined with -%s, which only changes the built-in methods", name)
 			}
 		}
-		g.pkg.files = files
+	}
+	if *schema && *tag != "json" {
+		exitf(exitUsage, "-schema describes JSON and requires -tag=json")
+	}
+	*outputSuffix = strings.TrimSuffix(*outputSuffix, ".go")
+	if *outputSuffix != "" {
+		if strings.ContainsAny(*outputSuffix, `/\`) || !isBuildableName("x"+*outputSuffix+".go") {
+			exitf(exitUsage, "invalid -output-suffix %q: file names ending in it must be Go files built on every platform", *outputSuffix)
+		}
+		if *output != "" {
+			exitf(exitUsage, "-output-suffix cannot be combined with -output")
+		}
+	}
+	if *variant != "" {
+		if !isVariant(*variant) {
+			exitf(exitUsage, "invalid -variant %q: must consist of letters, digits and underscores", *variant)
+		}
+		if !isBuildableName("x_" + *variant + ".go") {
+			exitf(exitUsage, "invalid -variant %q: file names ending in it would be left out of some builds", *variant)
+		}
+		if *buildTag == "" {
+			*buildTag = *variant
+		}
+	}
+	if *buildTag != "" {
+		if _, err := constraint.Parse("//go:build " + *buildTag); err != nil {
+			exitf(exitUsage, "invalid -build-tag %q: %s", *buildTag, err)
+		}
+	}
+	if _, ok := parseGoVersion(*sinceGoVersion); *sinceGoVersion != "" && !ok {
+		exitf(exitUsage, "invalid -since-go-version %q: must be a Go release such as 1.17", *sinceGoVersion)
+	}
+}
 
-	} else {
-		// TODO: add support files
-		log.Fatalf("not supported files")
-		os.Exit(2)
+// generatePackage generates the code for the named types of the package
+// in dir. With -recursive, found records the types found so far, and
+// packages without Go files or without any of the types are skipped.
+func generatePackage(dir string, types []string, found map[string]bool) {
+	recursing := found != nil
+	g := &Generator{}
+	g.pkg = &Package{}
+	g.suffix = strings.ToUpper(*tag)
+	p, err := build.Default.ImportDir(dir, 0)
+	if _, ok := err.(*build.NoGoError); ok && *ignoreBuildConstraints && len(p.IgnoredGoFiles) > 0 {
+		err = nil
+	}
+	if _, ok := err.(*build.NoGoError); ok && recursing {
+		verbosef("%s: no Go files, skipping", dir)
+		return
+	}
+	if err != nil {
+		exitf(exitParse, "cannot process directory %s: %s", dir, err)
+	}
+	g.pkg.dir = dir
+	g.pkg.name = p.Name
+	for _, name := range p.IgnoredGoFiles {
+		g.pkg.ignored = append(g.pkg.ignored, prefixDirectory(dir, name))
 	}
 
-	g.generateHead()
+	// TODO: support only gofile
+	goFiles := p.GoFiles
+	if *test {
+		goFiles = append(goFiles, p.TestGoFiles...)
+	}
+	if *ignoreBuildConstraints {
+		goFiles = append(goFiles, g.pkg.constrainedFiles(p.IgnoredGoFiles)...)
+	}
+	// Skip the output of earlier runs, so that its types aren't
+	// generated for again and a stale file doesn't break parsing.
+	// Code from other generators may well declare the types wanted.
+	var sources []string
+	for _, name := range goFiles {
+		if excluded, err := isExcludedFile(name); err != nil {
+			exitf(exitUsage, "invalid -exclude-files %q: %s", *excludeFiles, err)
+		} else if excluded {
+			verbosef("%s matches -exclude-files, skipping", name)
+			continue
+		}
+		generated, err := isGeneratedFile(prefixDirectory(dir, name), ownHeader)
+		if err != nil {
+			exitf(exitParse, "cannot process directory %s: %s", dir, err)
+		}
+		if generated {
+			verbosef("%s was generated by json_snake_case, skipping", name)
+			continue
+		}
+		sources = append(sources, name)
+	}
+	goFiles = sources
+	if len(goFiles) == 0 && recursing {
+		verbosef("%s: no Go source files, skipping", dir)
+		return
+	}
+	if len(goFiles) == 0 {
+		exitf(exitParse, "no Go source files in %s", dir)
+	}
+	files := make([]File, len(goFiles))
+	for i, v := range goFiles {
+		files[i] = File{
+			Name: prefixDirectory(g.pkg.dir, v),
+		}
+	}
+	g.pkg.files = files
 
 	fs := token.NewFileSet()
-	for i, v := range g.pkg.files {
-		parsedFile, err := parser.ParseFile(fs, v.Name, nil, 0)
+	if err := g.pkg.parseFiles(fs); err != nil {
+		exitf(exitParse, "parsing package: %s", err)
+	}
+	if *typeCheck {
+		g.pkg.check(fs)
+	}
+	if name := g.pkg.docFile(); *packageDoc != "" && name != "" {
+		exitf(exitUsage, "-package-doc: package %s already has a package comment in %s", g.pkg.name, name)
+	}
+
+	outputName := *output
+	if outputName == "" {
+		suffix := *outputSuffix
+		if suffix == "" {
+			suffix = "_" + *tag
+		}
+		baseName := types[0] + suffix
+		if types[0] == "*" {
+			baseName = g.pkg.name + suffix
+		} else if recursing {
+			// Name the file after a type of this package rather than
+			// after one that may be declared elsewhere.
+			for _, name := range types {
+				if g.pkg.declaredKind(name) == ast.Typ {
+					baseName = name + suffix
+					break
+				}
+			}
+		}
+		if *variant != "" {
+			baseName += "_" + *variant
+		}
+		// The file belongs to the package itself rather than to an
+		// external _test package, which couldn't refer to the unexported
+		// fields and types the generated code copies.
+		if *test {
+			baseName += "_test"
+		}
+		baseName += ".go"
+		outputName = filepath.Join(g.pkg.dir, strings.ToLower(baseName))
+	} else if !filepath.IsAbs(outputName) && filepath.Clean(filepath.Dir(outputName)) != filepath.Clean(g.pkg.dir) {
+		verbosef("writing %s relative to the current directory, outside package directory %s", outputName, g.pkg.dir)
+	}
+	if _, err := os.Stat(filepath.Dir(outputName)); os.IsNotExist(err) && !*printOnly && !*planJSON {
+		dir := filepath.Dir(outputName)
+		if !*mkdir {
+			exitf(exitWrite, "output directory %s does not exist; create it or pass -mkdir", dir)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			exitf(exitWrite, "creating output directory: %s", err)
+		}
+		verbosef("created output directory %s", dir)
+	}
+	_, err = os.Stat(outputName)
+	exists := err == nil
+	if exists && *outputMode == "skip-existing" {
+		verbosef("%s exists, skipping", outputName)
+		return
+	}
+	appending := exists && *outputMode == "append"
+	merging := exists && *outputMode == "merge"
+	if exists && *outputMode == "overwrite" && !*inlineRegion && !*noEditCheck && !*printOnly && !*planJSON {
+		if generated, err := isGeneratedFile(outputName, generatedHeader); err != nil {
+			exitf(exitWrite, "reading output: %s", err)
+		} else if !generated {
+			warnf("%s lacks the generated code header and may have been written by hand; overwriting it", outputName)
+		}
+	}
+	if *inlineRegion || appending || merging {
+		pkgName, err := g.useImports(outputName)
 		if err != nil {
-			log.Fatalf("parsing package: %s: %s", v.Name, err)
+			exitf(exitWrite, "reading output: %s", err)
+		}
+		if (appending || merging) && pkgName != g.pkg.name {
+			exitf(exitWrite, "cannot %s to %s: package %s, want %s", *outputMode, outputName, pkgName, g.pkg.name)
 		}
-		g.pkg.files[i].AstFile = parsedFile
 	}
 
+	var declared []string
 	for _, v := range g.pkg.files {
+		begin, end := generatedRegion(v.AstFile)
 		for _, decl := range v.AstFile.Decls {
 			genDecl, ok := decl.(*ast.GenDecl)
 			if !ok {
@@ -101,225 +456,2088 @@ func main() {
 					continue
 				}
 				name := typeSpec.Name.Name
-				if !contains(types, name) {
+				if !contains(types, name) && !contains(types, "*") {
+					continue
+				}
+				declared = append(declared, name)
+				if begin <= typeSpec.Pos() && typeSpec.Pos() < end {
+					verbosef("%s is declared in the generated region of %s, skipping", name, v.Name)
 					continue
 				}
 				structType, ok := typeSpec.Type.(*ast.StructType)
 				if !ok {
+					verbosef("%s is not a struct, skipping", name)
 					continue
 				}
-				g.generate(name, structType)
+				if contains(g.pkg.ignored, v.Name) {
+					// Typically declared for several platforms.
+					if g.typeNamed(name) != nil {
+						verbosef("%s is declared again in %s, skipping", name, v.Name)
+						continue
+					}
+					if *buildTag == "" {
+						warnf("%s is declared in %s, which is excluded by its build constraints or file name; the output may need -build-tag", name, v.Name)
+					}
+				}
+				if typeSpec.TypeParams != nil && g.goMinor < 18 {
+					g.goMinor = 18
+				}
+				doc := typeSpec.Doc
+				if doc == nil && len(genDecl.Specs) == 1 {
+					doc = genDecl.Doc
+				}
+				g.types = append(g.types, Type{
+					Name:       name,
+					Struct:     structType,
+					TypeParams: typeSpec.TypeParams,
+					Doc:        doc,
+					Pos:        sourcePos(fs.Position(typeSpec.Pos()), outputName),
+				})
 			}
 		}
 	}
 
-	// Format the output.
-	src := g.format()
+	if recursing {
+		for _, name := range declared {
+			found[name] = true
+		}
+		if len(g.types) == 0 {
+			verbosef("%s: no types to generate, skipping", dir)
+			return
+		}
+	}
 
-	// Write to file.
-	outputName := *output
-	if outputName == "" {
-		baseName := fmt.Sprintf("%s_json.go", types[0])
-		outputName = filepath.Join(g.pkg.dir, strings.ToLower(baseName))
+	var notFound []string
+	for _, name := range types {
+		if name != "*" && !contains(declared, name) && !recursing {
+			notFound = append(notFound, name)
+		}
 	}
-	err := ioutil.WriteFile(outputName, src, 0644)
-	if err != nil {
-		log.Fatalf("writing output: %s", err)
+	var notDeclared []string
+	for _, name := range notFound {
+		if kind := g.pkg.declaredKind(name); kind != ast.Bad {
+			log.Printf("%s is a %s, not a type", name, kind)
+			continue
+		}
+		if file := g.pkg.ignoredDeclaring(name); file != "" {
+			log.Printf("hint: %s is declared in %s, which is excluded by its build constraints or file name; -ignore-build-constraints includes it", name, file)
+		}
+		notDeclared = append(notDeclared, name)
+	}
+	if len(notFound) > 0 && len(notDeclared) == 0 {
+		os.Exit(exitNotFound)
+	}
+	notFound = notDeclared
+	if len(notFound) == 1 {
+		exitf(exitNotFound, "type %s not found in %s; only types declared at package level are supported", notFound[0], g.pkg.dir)
+	}
+	if len(notFound) > 1 {
+		exitf(exitNotFound, "types %s not found in %s; only types declared at package level are supported", strings.Join(notFound, ", "), g.pkg.dir)
 	}
-}
-
-type Generator struct {
-	buf bytes.Buffer
-	pkg *Package
-}
-
-func (g *Generator) Printf(format string, args ...interface{}) {
-	fmt.Fprintf(&g.buf, format, args...)
-}
-
-func (g *Generator) generateHead() {
-	g.Printf("// Code generated by \"json_snake_case %s\"; DO NOT EDIT\n", strings.Join(os.Args[1:], " "))
-	g.Printf("\n")
-	g.Printf("package %s", g.pkg.name)
-	g.Printf("\n")
-	g.Printf("import \"encoding/json\"\n")
-}
-
-func (g *Generator) generate(name string, structType *ast.StructType) {
-	g.Printf("type %sJSON struct {", name)
-	g.Printf("\n")
-	fieldNames := make([]string, len(structType.Fields.List))
-	for i, field := range structType.Fields.List {
-		fieldName := field.Names[0].Name
-		fieldNames[i] = fieldName
 
-		fieldType := types.ExprString(field.Type)
+	if *group {
+		sort.Slice(g.types, func(i, j int) bool {
+			return g.types[i].Name < g.types[j].Name
+		})
+	}
 
-		tagValue := ""
-		if field.Tag != nil {
-			tagValue = field.Tag.Value
+	if *planJSON {
+		if err := g.writePlan(os.Stdout, outputName); err != nil {
+			exitf(exitWrite, "writing plan: %s", err)
 		}
-		tagValue = addJsonTag(fieldName, tagValue)
+		return
+	}
 
-		g.Printf("%s %s %s", fieldName, fieldType, tagValue)
-		g.Printf("\n")
+	if *templateFile != "" {
+		tmpl, err := g.parseTemplate(*templateFile)
+		if err != nil {
+			exitf(exitUsage, "reading template: %s", err)
+		}
+		if err := g.generateTemplate(tmpl); err != nil {
+			exitf(exitOther, "executing template: %s", err)
+		}
+	} else {
+		for _, t := range g.types {
+			if *outputMode == "merge" {
+				g.Printf("%s %s\n", typeBegin, t.Name)
+			}
+			g.generate(t)
+			if *outputMode == "merge" {
+				g.Printf("%s %s\n", typeEnd, t.Name)
+				g.Printf("\n")
+			}
+		}
 	}
-	g.Printf("}\n")
 
-	g.Printf("\n")
+	if *group {
+		g.generateContents()
+	}
 
-	g.Printf("func (m %s) MarshalJSON() ([]byte, error) {\n", name)
-	g.Printf("	j := New%sJSON(&m)\n", name)
-	g.Printf("	return json.Marshal(j)\n")
-	g.Printf("}\n")
+	var src []byte
+	if *inlineRegion {
+		src, err = g.replaceRegion(outputName)
+		if err != nil {
+			exitf(exitWrite, "replacing region: %s", err)
+		}
+	} else if appending {
+		src, err = g.appendTo(outputName)
+		if err != nil {
+			exitf(exitWrite, "appending output: %s", err)
+		}
+	} else if merging {
+		src, err = g.mergeInto(outputName)
+		if err != nil {
+			exitf(exitWrite, "merging output: %s", err)
+		}
+	} else {
+		g.generateHead()
 
-	g.Printf("\n")
+		// Format the output.
+		src, err = g.format()
+		// With -strict, invalid code is an error and reported regardless
+		// of -quiet. So it is with -quiet, which would otherwise hide that
+		// the output was not written.
+		fatal := *strict || *quiet
+		report := warnf
+		if fatal {
+			report = log.Printf
+		}
+		if err != nil && *printOnly {
+			report("invalid Go generated: %s", err)
+			printNumbered(os.Stderr, g.buf.Bytes())
+			if fatal {
+				os.Exit(exitOther)
+			}
+			return
+		}
+		if err != nil {
+			// Should never happen, but can arise when developing this code
+			// or a template. Keep the output intact and leave the code for
+			// inspection.
+			broken := outputName + ".broken"
+			if err := ioutil.WriteFile(broken, g.buf.Bytes(), 0644); err != nil {
+				exitf(exitWrite, "writing output: %s", err)
+			}
+			report("internal error: invalid Go generated: %s", err)
+			report("wrote the unformatted code to %s, %s is unchanged", broken, outputName)
+			if fatal {
+				os.Exit(exitOther)
+			}
+			return
+		}
+	}
 
-	g.Printf("func New%sJSON(m *%s) *%sJSON {\n", name, name, name)
-	g.Printf("	return &%sJSON{\n", name)
-	for _, fieldName := range fieldNames {
-		g.Printf("		%s:  m.%s,\n", fieldName, fieldName)
+	if *checkOutput {
+		errs, err := g.pkg.checkOutput(fs, outputName, src)
+		if err != nil {
+			exitf(exitOther, "checking output: %s", err)
+		}
+		if len(errs) > 0 {
+			for _, err := range errs {
+				log.Printf("type error in generated code: %s", err)
+			}
+			if *printOnly {
+				printNumbered(os.Stderr, src)
+				os.Exit(exitOther)
+			}
+			broken := outputName + ".broken"
+			if err := ioutil.WriteFile(broken, src, 0644); err != nil {
+				exitf(exitWrite, "writing output: %s", err)
+			}
+			exitf(exitOther, "wrote the generated code to %s, %s is unchanged", broken, outputName)
+		}
 	}
-	g.Printf("	}\n")
-	g.Printf("}\n")
 
-	g.Printf("\n")
-}
+	if *printOnly {
+		printNumbered(os.Stderr, src)
+		return
+	}
 
-func addJsonTag(fieldName string, tagValue string) string {
-	if len(tagValue) >= 2 {
-		tagValue = tagValue[1 : len(tagValue)-1]
+	// Write to file.
+	err = ioutil.WriteFile(outputName, src, 0644)
+	if err != nil {
+		exitf(exitWrite, "writing output: %s", err)
 	}
-	tags := tagParser(tagValue)
-	jsonTag, ok := tags["json"]
-	if ok {
-		if strings.HasPrefix(jsonTag, ",") {
-			tags["json"] = CamelToSnake(fieldName) + tags["json"]
+
+	if *schema {
+		doc, err := g.generateSchema()
+		if err != nil {
+			exitf(exitOther, "generating schema: %s", err)
+		}
+		schemaName := strings.TrimSuffix(outputName, ".go") + ".schema.json"
+		if err := ioutil.WriteFile(schemaName, append(doc, '\n'), 0644); err != nil {
+			exitf(exitWrite, "writing schema: %s", err)
 		}
-	} else {
-		tags["json"] = CamelToSnake(fieldName)
 	}
-	tagValue = tagString(tags)
-	if tagValue == "" {
-		return ""
+
+	if !*quiet {
+		g.printSummary(outputName)
 	}
-	return fmt.Sprintf("`%s`", tagValue)
 }
 
-// format returns the gofmt-ed contents of the Generator's buffer.
-func (g *Generator) format() []byte {
-	src, err := format.Source(g.buf.Bytes())
+// packageDirs returns root and the directories below it, apart from
+// those the go command ignores in ./... patterns: vendor and testdata
+// directories, and those whose name begins with a dot or an underscore.
+func packageDirs(root string) []string {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if path != root && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
 	if err != nil {
-		// Should never happen, but can arise when developing this code.
-		// The user can compile the output to see the error.
-		log.Printf("warning: internal error: invalid Go generated: %s", err)
-		log.Printf("warning: compile the package to analyze the error")
-		return g.buf.Bytes()
+		exitf(exitParse, "cannot walk %s: %s", root, err)
 	}
-	return src
+	return dirs
 }
 
-type Package struct {
-	dir   string
-	name  string
-	files []File
+// printSummary logs the number of types and fields generated into outputName.
+func (g *Generator) printSummary(outputName string) {
+	fields, skipped := 0, 0
+	for _, st := range g.stats {
+		log.Printf("%s: %d fields, %d skipped", st.Type, st.Fields, st.Skipped)
+		fields += st.Fields
+		skipped += st.Skipped
+	}
+	log.Printf("wrote %s: %d types, %d fields, %d skipped", outputName, len(g.stats), fields, skipped)
 }
 
-type File struct {
-	Name    string
-	AstFile *ast.File
+type Generator struct {
+	buf     bytes.Buffer
+	pkg     *Package
+	types   []Type            // the types to generate, in source order
+	imports map[string]string // import path to the name used in generated code
+	suffix  string            // appended to a type name to name its generated struct, e.g. "JSON"
+	marshal string            // name of the pooled marshal function, once generated
+
+	stats       []Stats      // per generated type, for the summary
+	schemaTypes []schemaType // types described by the -schema output
+	fileImports []string     // import paths of the -inline-region file
+	goMinor     int          // minor version of the Go release the output needs, if known
+	ctx         string       // with -with-context, the context passed to constructors
 }
 
-// isDirectory reports whether the named file is a directory.
-func isDirectory(name string) bool {
-	info, err := os.Stat(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return info.IsDir()
+func (g *Generator) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
 }
 
-func prefixDirectory(directory string, name string) string {
-	if directory == "." {
+// addImport records an import path required by the generated code and
+// returns the name the generated code must refer to the package by. The
+// import is renamed when its name is also declared in the target package.
+// Fields named like the package, e.g. json, don't need that: they are only
+// referred to through selectors such as m.json.
+func (g *Generator) addImport(path string) string {
+	if name, ok := g.imports[path]; ok {
 		return name
 	}
-	return filepath.Join(directory, name)
-}
-
-// utils
-
-func contains(list []string, key string) bool {
-	for _, v := range list {
-		if v == key {
-			return true
+	base := path[strings.LastIndex(path, "/")+1:]
+	name := base
+	for i := 1; name == g.pkg.name || g.pkg.declares(name) || g.importsName(name); i++ {
+		name = base + "pkg"
+		if i > 1 {
+			name += strconv.Itoa(i)
 		}
 	}
-	return false
-}
-
-func tagParser(input string) map[string]string {
-	tags := make(map[string]string)
-	list := tagRegex.FindAllStringSubmatch(input, -1)
-	for _, v := range list {
-		tags[v[1]] = v[4]
+	if g.imports == nil {
+		g.imports = make(map[string]string)
 	}
-	return tags
+	g.imports[path] = name
+	return name
 }
 
-func tagString(tags map[string]string) string {
-	output := ""
-	for i, v := range tags {
-		if v == "" {
-			output = fmt.Sprintf("%s %s", output, i)
-			continue
+// importsName reports whether an import already uses name.
+func (g *Generator) importsName(name string) bool {
+	for _, n := range g.imports {
+		if n == name {
+			return true
 		}
-		output = fmt.Sprintf(`%s %s:"%s"`, output, i, v)
 	}
-	return strings.TrimPrefix(output, " ")
+	return false
 }
 
-func CamelToSnake(s string) string {
-	var result string
-	var words []string
-	var lastPos int
-	rs := []rune(s)
-	for i := 0; i < len(rs); i++ {
-		if i > 0 && unicode.IsUpper(rs[i]) {
-			if initialism := startsWithInitialism(s[lastPos:]); initialism != "" {
-				words = append(words, initialism)
+// generateHead prepends the header, package clause and imports to the
+// already generated declarations, so that only used packages are imported.
+func (g *Generator) generateHead() {
+	body := append([]byte(nil), g.buf.Bytes()...)
+	g.buf.Reset()
 
-				i += len(initialism) - 1
-				lastPos = i
-				continue
-			}
-			words = append(words, s[lastPos:i])
-			lastPos = i
+	// The header only depends on the arguments, so that running the same
+	// command again gives the same file: no times, hosts or versions.
+	g.Printf("// Code generated by \"json_snake_case %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
+	g.Printf("\n")
+	if expr := g.buildConstraint(); expr != "" {
+		g.Printf("//go:build %s\n", expr)
+		g.Printf("\n")
+	}
+	if *packageDoc != "" {
+		for _, line := range strings.Split("Package "+g.pkg.name+" "+*packageDoc, "\n") {
+			g.Printf("// %s\n", line)
 		}
 	}
-	if s[lastPos:] != "" {
-		words = append(words, s[lastPos:])
+	g.Printf("package %s", g.pkg.name)
+	g.Printf("\n")
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
 	}
-	for k, word := range words {
-		if k > 0 {
-			result += "_"
+	sort.Strings(paths)
+	if len(paths) > 1 {
+		g.Printf("import (\n")
+	}
+	for _, path := range paths {
+		if len(paths) == 1 {
+			g.Printf("import ")
 		}
-		result += strings.ToLower(word)
+		if name := g.imports[path]; name != path[strings.LastIndex(path, "/")+1:] {
+			g.Printf("%s ", name)
+		}
+		g.Printf("\"%s\"\n", path)
 	}
-	return result
+	if len(paths) > 1 {
+		g.Printf(")\n")
+	}
+	g.Printf("\n")
+	g.buf.Write(body)
 }
 
-// startsWithInitialism returns the initialism if the given string begins with it
-func startsWithInitialism(s string) string {
-	var initialism string
-	// the longest initialism is 5 char, the shortest 2
-	for i := 1; i <= 5; i++ {
-		if len(s) > i-1 && commonInitialisms[s[:i]] {
-			initialism = s[:i]
+// generateContents prepends a comment listing the generated types, in the
+// order of the file, to the already generated declarations.
+func (g *Generator) generateContents() {
+	body := append([]byte(nil), g.buf.Bytes()...)
+	g.buf.Reset()
+
+	g.Printf("// Generated types, by name:\n")
+	g.Printf("//\n")
+	for _, st := range g.stats {
+		g.Printf("//	%s%s for %s\n", st.Type, g.suffix, st.Type)
+	}
+	g.Printf("\n")
+	g.buf.Write(body)
+}
+
+// buildConstraint returns the build constraint of the output: -build-tag,
+// and the Go release the generated code needs if it is newer than
+// -since-go-version.
+func (g *Generator) buildConstraint() string {
+	expr := *buildTag
+	if since, _ := parseGoVersion(*sinceGoVersion); since == 0 || g.goMinor <= since {
+		return expr
+	}
+	release := fmt.Sprintf("go1.%d", g.goMinor)
+	if expr == "" {
+		return release
+	}
+	return "(" + expr + ") && " + release
+}
+
+// noteGoVersion records the Go release the field type expr needs:
+// Go 1.18 for instantiated generic types and the predeclared any.
+func (g *Generator) noteGoVersion(expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		needs := false
+		switch t := n.(type) {
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			// In a field type, only a type instantiation: array
+			// lengths are ArrayType.Len.
+			needs = true
+		case *ast.Ident:
+			needs = t.Name == "any" && !g.pkg.declares("any")
+		}
+		if needs && g.goMinor < 18 {
+			g.goMinor = 18
+		}
+		return true
+	})
+}
+
+// parseGoVersion returns the minor version of a Go 1 release such as
+// "1.17" or "go1.17".
+func parseGoVersion(s string) (int, bool) {
+	m := goVersion.FindStringSubmatch(s)
+	if m == nil {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(m[1])
+	return minor, err == nil
+}
+
+var goVersion = regexp.MustCompile(`^(?:go)?1\.(\d+)$`)
+
+func (g *Generator) generate(t Type) {
+	name, structType := t.Name, t.Struct
+	fields := g.fields(name, structType)
+	// The source and generated types as instantiated with the type
+	// parameters of a generic type, e.g. Page[T] and PageJSON[T].
+	instance, shadow := name+t.typeArgs(), name+g.suffix+t.typeArgs()
+	if g.skipNoop(name, fields) {
+		return
+	}
+	warnFieldCount(name, fields)
+	if *sourcePosition {
+		g.Printf("// %s%s is the %s serialization view of %s (from %s).\n", name, g.suffix, g.suffix, name, t.Pos)
+	} else {
+		g.Printf("// %s%s is the %s serialization view of %s.\n", name, g.suffix, g.suffix, name)
+	}
+	if text := t.Doc.Text(); text != "" {
+		g.Printf("//\n")
+		g.Printf("%s\n", comment(text))
+	}
+	g.Printf("type %s%s%s struct {", name, g.suffix, t.typeParams())
+	g.Printf("\n")
+	for _, f := range fields {
+		for _, directive := range directives(f.Source.Doc) {
+			g.Printf("%s\n", directive)
+		}
+		if f.Embedded {
+			g.Printf("%s %s", g.shadowType(f.Type), f.Tag)
+		} else {
+			g.Printf("%s %s %s", f.Name, g.fieldType(f), f.Tag)
+		}
+		if line := directives(f.Source.Comment); len(line) > 0 {
+			g.Printf(" %s", strings.Join(line, " "))
+		}
+		g.Printf("\n")
+	}
+	g.Printf("}\n")
+
+	g.Printf("\n")
+
+	// A MarshalJSON method declared in a _test.go file would make the type
+	// serialize differently under test than in production, so test mode
+	// emits a Marshal<Type>JSON function in its place, for tests to call
+	// explicitly, and none of the other methods. Other tag keys have no
+	// Marshaler the generated code could implement without importing
+	// their packages.
+	if *tag == "json" {
+		jsonPkg := g.addImport("encoding/json")
+		marshal := ""
+		if *bufferPool {
+			marshal = g.generateEncoderPool(name)
+		}
+		if *withContext {
+			g.ctx = g.addImport("context") + ".TODO()"
+		}
+		// A generated struct from a pool of the type is encoded without
+		// being allocated. Generic types can't have package-level pools.
+		values := ""
+		if marshal != "" && t.TypeParams == nil {
+			values = g.generateValuePool(name)
+		}
+		if *test {
+			g.Printf("func Marshal%s%s%s(m *%s) ([]byte, error) {\n", name, g.suffix, t.typeParams(), instance)
+		} else {
+			g.Printf("func (m %s) MarshalJSON() ([]byte, error) {\n", instance)
+		}
+		arg := "j"
+		if values != "" {
+			g.Printf("	j := %s.Get().(*%s)\n", values, shadow)
+			g.Printf("	defer func() {\n")
+			g.Printf("		*j = %s{}\n", shadow)
+			g.Printf("		%s.Put(j)\n", values)
+			g.Printf("	}()\n")
+			g.generateCopy(shadow, "m", fields, true, "j")
+		} else if *test {
+			g.Printf("	j := %s\n", g.newCall(name, "m"))
+		} else {
+			g.Printf("	j := %s\n", g.newCall(name, "&m"))
+		}
+		// Marshalling a pointer to a struct returned by value saves
+		// boxing a copy of it.
+		if *valueConstructor && values == "" {
+			arg = "&j"
+		}
+		if *sortKeys {
+			g.generateSortedMarshal(marshal, arg)
+		} else if marshal != "" {
+			g.Printf("	return %s(%s)\n", marshal, arg)
+		} else if *indent != "" {
+			g.Printf("	return %s.MarshalIndent(%s, \"\", %q)\n", jsonPkg, arg, *indent)
+		} else {
+			g.Printf("	return %s.Marshal(%s)\n", jsonPkg, arg)
+		}
+		g.Printf("}\n")
+
+		g.Printf("\n")
+
+		if *genPartial && !*test {
+			g.generatePartial(t)
+		}
+		if *genWriter && !*test {
+			g.generateWriter(t)
+		}
+	}
+
+	// The conversions refer to the field types, so their locals must not
+	// shadow the names those types use.
+	used := fieldTypeNames(name, fields)
+	if typeArgs := t.typeArgs(); typeArgs != "" {
+		for _, param := range strings.Split(strings.Trim(typeArgs, "[]"), ", ") {
+			used[param] = true
+		}
+	}
+	m, j := localName("m", used), localName("j", used)
+	result, literal := "*"+shadow, "&"+shadow
+	if *valueConstructor {
+		result, literal = shadow, shadow
+	}
+	if *withContext {
+		g.ctx = localName("ctx", used)
+		g.Printf("func New%s%s%s(%s %s.Context, %s *%s) %s {\n", name, g.suffix, t.typeParams(), g.ctx, g.addImport("context"), m, instance, result)
+	} else {
+		g.Printf("func New%s%s%s(%s *%s) %s {\n", name, g.suffix, t.typeParams(), m, instance, result)
+	}
+	g.generateCopy(literal, m, fields, true, "")
+	g.Printf("}\n")
+
+	g.Printf("\n")
+
+	g.Printf("func (%s *%s) To%s() %s {\n", j, shadow, name, instance)
+	g.generateCopy(instance, j, fields, false, "")
+	g.Printf("}\n")
+
+	g.Printf("\n")
+
+	if *genSliceHelper {
+		g.generateSliceHelper(t, used, result)
+	}
+	if *genValidate {
+		g.generateValidate(t, fields)
+	}
+	if *genFixture {
+		g.generateFixture(t, fields)
+	}
+	if *schema {
+		g.addSchema(name, fields)
+	}
+}
+
+// skipNoop reports whether -skip-noop leaves out the named type, whose
+// generated struct has fields, and removes it from the summary if so.
+func (g *Generator) skipNoop(name string, fields []Field) bool {
+	if !*skipNoop || *nested || !g.isNoop(fields) {
+		return false
+	}
+	verbosef("%s: source tags match the generated ones, skipping", name)
+	g.stats = g.stats[:len(g.stats)-1]
+	return true
+}
+
+// warnFieldCount warns if the named type has more fields than -warn-fields.
+func warnFieldCount(name string, fields []Field) {
+	if *warnFields > 0 && len(fields) > *warnFields {
+		warnf("%s has %d fields, more than -warn-fields=%d; consider splitting it", name, len(fields), *warnFields)
+	}
+}
+
+// comment returns text as // comment lines, without a final newline.
+func comment(text string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		lines = append(lines, strings.TrimSpace("// "+line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// generateEncoderPool emits, once per file, a sync.Pool of buffers with
+// encoders writing to them and a function marshalling through it. It
+// returns the name of that function. Declarations are named after the
+// first type generated, so that files generated separately for one
+// package don't collide.
+func (g *Generator) generateEncoderPool(name string) string {
+	if g.marshal != "" {
+		return g.marshal
+	}
+	prefix := lowerFirst(name) + g.suffix
+	g.marshal = prefix + "Marshal"
+	bytesPkg := g.addImport("bytes")
+	jsonPkg := g.addImport("encoding/json")
+	syncPkg := g.addImport("sync")
+
+	g.Printf("// %sEncoder is a reusable buffer and the encoder writing to it.\n", prefix)
+	g.Printf("type %sEncoder struct {\n", prefix)
+	g.Printf("	buf %s.Buffer\n", bytesPkg)
+	g.Printf("	enc *%s.Encoder\n", jsonPkg)
+	g.Printf("}\n")
+
+	g.Printf("\n")
+
+	g.Printf("var %sEncoders = %s.Pool{\n", prefix, syncPkg)
+	g.Printf("	New: func() interface{} {\n")
+	g.Printf("		e := new(%sEncoder)\n", prefix)
+	g.Printf("		e.enc = %s.NewEncoder(&e.buf)\n", jsonPkg)
+	if *indent != "" {
+		g.Printf("		e.enc.SetIndent(\"\", %q)\n", *indent)
+	}
+	g.Printf("		return e\n")
+	g.Printf("	},\n")
+	g.Printf("}\n")
+
+	g.Printf("\n")
+
+	g.Printf("// %s is like json.Marshal, but reuses a pooled buffer and encoder.\n", g.marshal)
+	g.Printf("func %s(v interface{}) ([]byte, error) {\n", g.marshal)
+	g.Printf("	e := %sEncoders.Get().(*%sEncoder)\n", prefix, prefix)
+	g.Printf("	defer %sEncoders.Put(e)\n", prefix)
+	g.Printf("	e.buf.Reset()\n")
+	g.Printf("	if err := e.enc.Encode(v); err != nil {\n")
+	g.Printf("		return nil, err\n")
+	g.Printf("	}\n")
+	g.Printf("	// Encode terminates the value with a newline, which Marshal doesn't.\n")
+	g.Printf("	b := e.buf.Bytes()\n")
+	g.Printf("	return append([]byte(nil), b[:len(b)-1]...), nil\n")
+	g.Printf("}\n")
+
+	g.Printf("\n")
+	return g.marshal
+}
+
+// generateValuePool emits a sync.Pool of the generated struct of the
+// named type, for MarshalJSON to fill and encode with -buffer-pool, and
+// returns its name.
+func (g *Generator) generateValuePool(name string) string {
+	pool := lowerFirst(name) + g.suffix + "Values"
+	g.Printf("var %s = %s.Pool{\n", pool, g.addImport("sync"))
+	g.Printf("	New: func() interface{} { return new(%s%s) },\n", name, g.suffix)
+	g.Printf("}\n")
+
+	g.Printf("\n")
+	return pool
+}
+
+// Stats counts the fields of a generated type.
+type Stats struct {
+	Type    string
+	Fields  int    // fields of the generated struct
+	Skipped int    // fields of the source type left out
+	Skips   []Skip // the fields left out, for -plan-json
+}
+
+// Skip is a field of the source type left out of the generated struct.
+type Skip struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// skip counts the fields field declares as left out for reason.
+func (st *Stats) skip(field *ast.Field, reason string) {
+	names := []string{embeddedFieldName(field.Type)}
+	if len(field.Names) > 0 {
+		names = nil
+		for _, ident := range field.Names {
+			names = append(names, ident.Name)
+		}
+	}
+	for _, name := range names {
+		st.Skips = append(st.Skips, Skip{Name: name, Reason: reason})
+	}
+	st.Skipped += len(names)
+}
+
+// Type is a struct type to generate code for.
+type Type struct {
+	Name       string
+	Struct     *ast.StructType
+	TypeParams *ast.FieldList // nil unless the type is generic
+	Doc        *ast.CommentGroup
+	Pos        string // file:line of the declaration, relative to the output
+}
+
+// typeParams returns the type parameter list of the declaration of t,
+// e.g. "[K comparable, V any]", or "" if t isn't generic.
+func (t Type) typeParams() string {
+	if t.TypeParams == nil {
+		return ""
+	}
+	var params []string
+	for _, field := range t.TypeParams.List {
+		var names []string
+		for _, ident := range field.Names {
+			names = append(names, ident.Name)
+		}
+		params = append(params, strings.Join(names, ", ")+" "+types.ExprString(field.Type))
+	}
+	return "[" + strings.Join(params, ", ") + "]"
+}
+
+// typeArgs returns the type parameters of t as the arguments instantiating
+// a type with them, e.g. "[K, V]", or "" if t isn't generic.
+func (t Type) typeArgs() string {
+	if t.TypeParams == nil {
+		return ""
+	}
+	var names []string
+	for _, field := range t.TypeParams.List {
+		for _, ident := range field.Names {
+			names = append(names, ident.Name)
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+// Field is a field of a generated struct.
+type Field struct {
+	Name     string // the type name for embedded fields
+	Type     ast.Expr
+	Tag      string // raw string literal, or "" when it has no tag
+	Key      string // name the field is serialized as, "" for embedded fields
+	Embedded bool
+	Deref    bool     // a pointer field generated as the type pointed to
+	Mapped   ast.Expr // the type of the generated field given by -type-map or -null-type, if any
+	Null     bool     // a -null-type field, Mapped to the value or nil
+	Promoted bool     // a field of an embedded struct, with -flatten-embedded
+	Source   *ast.Field
+}
+
+// fields returns the fields of the struct generated for the named type.
+func (g *Generator) fields(name string, structType *ast.StructType) []Field {
+	var fields, embedded []Field
+	stats := Stats{Type: name}
+	defer func() {
+		stats.Fields = len(fields)
+		g.stats = append(g.stats, stats)
+	}()
+	for _, field := range structType.Fields.List {
+		tagValue := ""
+		if field.Tag != nil {
+			tagValue = field.Tag.Value
+		}
+
+		if onlyTagged.set {
+			key := onlyTagged.value
+			if key == "" {
+				key = *tag
+			}
+			if _, ok := tagParser(unquoteTag(tagValue)).Lookup(key); !ok {
+				stats.skip(field, "no "+key+" tag, with -only-tagged")
+				continue
+			}
+		}
+
+		if *requireTag != "" && !hasRequiredTag(tagValue) {
+			stats.skip(field, "no "+*requireTag+" tag, with -require-tag")
+			continue
+		}
+
+		// The snake tag only configures this tool.
+		snakeOptions := snakeTagOptions(name, tagValue)
+		tagValue = withoutTag(tagValue, "snake")
+
+		g.noteGoVersion(field.Type)
+		if len(field.Names) == 0 {
+			// Embedded field: it is copied by its type name.
+			embeddedTag := generatedEmbeddedTag(tagValue)
+			embeddedName := embeddedFieldName(field.Type)
+			if promoted := g.promotedFields(embeddedName, field.Type, embeddedTag); promoted != nil {
+				embedded = append(embedded, promoted...)
+				continue
+			}
+			if !*test && *tag == "json" && !*nested && g.typeNamed(embeddedName) != nil {
+				advice := "-nested or -flatten-embedded"
+				if *flattenEmbedded {
+					advice = "-nested for pointers"
+				}
+				warnf("%s embeds %s, whose MarshalJSON is promoted to %s%s and marshals %s alone; use %s", name, embeddedName, name, g.suffix, embeddedName, advice)
+			}
+			fields = append(fields, Field{
+				Name:     embeddedName,
+				Type:     field.Type,
+				Tag:      embeddedTag,
+				Embedded: true,
+				Source:   field,
+			})
+			continue
+		}
+
+		for _, ident := range field.Names {
+			fieldName := ident.Name
+			g.checkFieldType(name, fieldName, field.Type)
+
+			fieldTag := generatedTag(fieldName, tagValue, tagOptions(name, snakeOptions))
+			star, isPointer := field.Type.(*ast.StarExpr)
+			if isPointer && *derefPointers {
+				// A generated type held by value may contain itself.
+				if ident, ok := star.X.(*ast.Ident); ok && g.isGenerated(ident.Name) {
+					verbosef("%s.%s: pointer to generated type %s is kept", name, fieldName, ident.Name)
+					isPointer = false
+				}
+			}
+			mapped := mappedTypes[types.ExprString(field.Type)]
+			null := mapped == nil && *nullType != ""
+			if null {
+				mapped = nullValueType(field.Type)
+				null = mapped != nil
+			}
+			fields = append(fields, Field{
+				Name:   fieldName,
+				Type:   field.Type,
+				Tag:    fieldTag,
+				Key:    tagName(fieldTag, *tag),
+				Deref:  isPointer && *derefPointers && mapped == nil,
+				Mapped: mapped,
+				Null:   null,
+				Source: field,
+			})
+		}
+	}
+	// Promoted fields come after the others and are shadowed by them, as
+	// in Go.
+	for _, f := range embedded {
+		if containsField(fields, f.Name) {
+			verbosef("%s.%s: promoted field is shadowed, skipping", name, f.Name)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// tagOptions returns the options to add to the generated tags of the
+// fields of the named type, given those of their snake tag.
+func tagOptions(name string, snakeOptions []string) []string {
+	var options []string
+	if omitEmpty.includes(name) {
+		options = append(options, "omitempty")
+	}
+	if omitZero.includes(name) {
+		options = append(options, "omitzero")
+	}
+	for _, option := range snakeOptions {
+		if !contains(options, option) {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// generatedTag returns the tag of the generated field of the named field
+// with the source tag tagValue, for -tag and -also-tag.
+func generatedTag(fieldName string, tagValue string, options []string) string {
+	fieldTag := addTag(*tag, fieldName, tagValue, options...)
+	for _, key := range alsoTags() {
+		fieldTag = addTag(key, fieldName, fieldTag, options...)
+	}
+	return fieldTag
+}
+
+// generatedEmbeddedTag returns the tag of the generated embedded field
+// with the source tag tagValue, for -tag and -also-tag.
+func generatedEmbeddedTag(tagValue string) string {
+	embeddedTag := addEmbeddedTag(*tag, tagValue)
+	for _, key := range alsoTags() {
+		embeddedTag = addEmbeddedTag(key, embeddedTag)
+	}
+	return embeddedTag
+}
+
+// promotedFields returns the fields of the embedded field of type expr to
+// generate in its place with -flatten-embedded, or nil if it is kept as it
+// is: only embedded structs generated in this run and not given a name by
+// their tag are flattened, and pointers to them aren't.
+func (g *Generator) promotedFields(name string, expr ast.Expr, tagValue string) []Field {
+	t := g.typeNamed(name)
+	if !*flattenEmbedded || t == nil || tagName(tagValue, *tag) != "" {
+		return nil
+	}
+	if _, ok := expr.(*ast.Ident); !ok {
+		verbosef("embedded %s is not flattened: it is a pointer", types.ExprString(expr))
+		return nil
+	}
+	fields := g.fields(t.Name, t.Struct)
+	g.stats = g.stats[:len(g.stats)-1]
+	for i := range fields {
+		fields[i].Promoted = true
+	}
+	return fields
+}
+
+// typeNamed returns the type of the given name generated in this run, or
+// nil if there is none.
+func (g *Generator) typeNamed(name string) *Type {
+	for i := range g.types {
+		if g.types[i].Name == name {
+			return &g.types[i]
+		}
+	}
+	return nil
+}
+
+// containsField reports whether fields has a field of the given name.
+func containsField(fields []Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// generateSortedMarshal emits the rest of a MarshalJSON body marshalling
+// arg, the generated struct j or its address, with the keys of all
+// objects sorted: the JSON is decoded into maps, which encoding/json
+// marshals sorted, and marshalled again. Numbers are decoded as
+// json.Number to keep them as they are.
+func (g *Generator) generateSortedMarshal(marshal string, arg string) {
+	jsonPkg := g.addImport("encoding/json")
+	if marshal == "" {
+		marshal = jsonPkg + ".Marshal"
+	}
+	g.Printf("	b, err := %s(%s)\n", marshal, arg)
+	g.Printf("	if err != nil {\n")
+	g.Printf("		return nil, err\n")
+	g.Printf("	}\n")
+	g.Printf("	d := %s.NewDecoder(%s.NewReader(b))\n", jsonPkg, g.addImport("bytes"))
+	g.Printf("	d.UseNumber()\n")
+	g.Printf("	var v interface{}\n")
+	g.Printf("	if err := d.Decode(&v); err != nil {\n")
+	g.Printf("		return nil, err\n")
+	g.Printf("	}\n")
+	if *indent != "" {
+		g.Printf("	return %s.MarshalIndent(v, \"\", %q)\n", jsonPkg, *indent)
+	} else {
+		g.Printf("	return %s.Marshal(v)\n", jsonPkg)
+	}
+}
+
+// generateWriter emits a WriteJSON method encoding the value to an
+// io.Writer, followed by a newline, without an intermediate []byte.
+// Under -sort-keys the value itself is encoded, so that its MarshalJSON
+// sorts the keys.
+func (g *Generator) generateWriter(t Type) {
+	name := t.Name
+	jsonPkg := g.addImport("encoding/json")
+	g.Printf("func (m %s%s) WriteJSON(w %s.Writer) error {\n", name, t.typeArgs(), g.addImport("io"))
+	g.Printf("	enc := %s.NewEncoder(w)\n", jsonPkg)
+	if *indent != "" {
+		g.Printf("	enc.SetIndent(\"\", %q)\n", *indent)
+	}
+	if *sortKeys {
+		g.Printf("	return enc.Encode(m)\n")
+	} else {
+		g.Printf("	return enc.Encode(%s)\n", g.newCall(name, "&m"))
+	}
+	g.Printf("}\n")
+
+	g.Printf("\n")
+}
+
+// generatePartial emits a MarshalJSONFields method marshalling only the
+// top-level keys in a set. The full JSON is decoded into a map and the
+// other keys deleted, so that omitempty, promoted fields and the
+// Marshalers of field types apply as in MarshalJSON. The keys come out
+// sorted.
+func (g *Generator) generatePartial(t Type) {
+	name := t.Name
+	jsonPkg := g.addImport("encoding/json")
+	g.Printf("func (m %s%s) MarshalJSONFields(fields map[string]bool) ([]byte, error) {\n", name, t.typeArgs())
+	g.Printf("	b, err := %s.Marshal(%s)\n", jsonPkg, g.newCall(name, "&m"))
+	g.Printf("	if err != nil {\n")
+	g.Printf("		return nil, err\n")
+	g.Printf("	}\n")
+	g.Printf("	var all map[string]%s.RawMessage\n", jsonPkg)
+	g.Printf("	if err := %s.Unmarshal(b, &all); err != nil {\n", jsonPkg)
+	g.Printf("		return nil, err\n")
+	g.Printf("	}\n")
+	g.Printf("	for key := range all {\n")
+	g.Printf("		if !fields[key] {\n")
+	g.Printf("			delete(all, key)\n")
+	g.Printf("		}\n")
+	g.Printf("	}\n")
+	if *indent != "" {
+		g.Printf("	return %s.MarshalIndent(all, \"\", %q)\n", jsonPkg, *indent)
+	} else {
+		g.Printf("	return %s.Marshal(all)\n", jsonPkg)
+	}
+	g.Printf("}\n")
+
+	g.Printf("\n")
+}
+
+// generateSliceHelper emits a New<Type>JSONSlice function applying the
+// constructor, whose result type is result, to each element of a slice.
+// A nil slice gives nil. used holds the names the locals must avoid.
+func (g *Generator) generateSliceHelper(t Type, used map[string]bool, result string) {
+	name := t.Name
+	ms, i := localName("ms", used), localName("i", used)
+	if *withContext {
+		g.ctx = localName("ctx", used)
+		g.Printf("func New%s%sSlice%s(%s %s.Context, %s []%s%s) []%s {\n", name, g.suffix, t.typeParams(), g.ctx, g.addImport("context"), ms, name, t.typeArgs(), result)
+	} else {
+		g.Printf("func New%s%sSlice%s(%s []%s%s) []%s {\n", name, g.suffix, t.typeParams(), ms, name, t.typeArgs(), result)
+	}
+	g.Printf("	if %s == nil {\n", ms)
+	g.Printf("		return nil\n")
+	g.Printf("	}\n")
+	js := localName("js", used)
+	g.Printf("	%s := make([]%s, len(%s))\n", js, result, ms)
+	g.Printf("	for %s := range %s {\n", i, ms)
+	g.Printf("		%s[%s] = %s\n", js, i, g.newCall(name, "&"+ms+"["+i+"]"))
+	g.Printf("	}\n")
+	g.Printf("	return %s\n", js)
+	g.Printf("}\n")
+
+	g.Printf("\n")
+}
+
+// generateValidate emits a Validate method checking the fields tagged
+// `validate:"required"`: strings must be non-empty and pointers non-nil.
+// Other validations and field types are not checked.
+func (g *Generator) generateValidate(t Type, fields []Field) {
+	name := t.Name
+	g.Printf("func (m %s%s) Validate() error {\n", name, t.typeArgs())
+	for _, f := range fields {
+		rules, _ := tagParser(unquoteTag(f.Tag)).Lookup("validate")
+		if f.Embedded || !contains(strings.Split(rules, ","), "required") {
+			continue
+		}
+		zero := ""
+		switch t := f.Type.(type) {
+		case *ast.Ident:
+			if t.Name == "string" {
+				zero = `""`
+			}
+		case *ast.StarExpr:
+			zero = "nil"
+		}
+		if zero == "" {
+			verbosef("%s.%s: required %s is not validated", name, f.Name, types.ExprString(f.Type))
+			continue
+		}
+		g.Printf("	if m.%s == %s {\n", f.Name, zero)
+		g.Printf("		return %s.New(%q)\n", g.addImport("errors"), f.Key+" is required")
+		g.Printf("	}\n")
+	}
+	g.Printf("	return nil\n")
+	g.Printf("}\n")
+
+	g.Printf("\n")
+}
+
+// isNoop reports whether the generated struct of fields would serialize
+// like the source struct: every field keeps its type and the tags of
+// -tag and -also-tag are those of the source.
+func (g *Generator) isNoop(fields []Field) bool {
+	keys := append([]string{*tag}, alsoTags()...)
+	for _, f := range fields {
+		if g.fieldNeedsConversion(f) || f.Promoted {
+			return false
+		}
+		source := ""
+		if f.Source.Tag != nil {
+			source = f.Source.Tag.Value
+		}
+		for _, key := range keys {
+			want, wantOK := tagParser(unquoteTag(source)).Lookup(key)
+			got, gotOK := tagParser(unquoteTag(f.Tag)).Lookup(key)
+			if want != got || wantOK != gotOK {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mappedTypes maps the source field types of -type-map, as formatted by
+// types.ExprString, to the types of the generated fields.
+var mappedTypes = map[string]ast.Expr{}
+
+// snakeTagOptions returns the options of the snake tag in tagValue, e.g.
+// omitempty for snake:",omitempty", to add to the generated tags of the
+// field of the named type.
+func snakeTagOptions(name string, tagValue string) []string {
+	value, ok := tagParser(unquoteTag(tagValue)).Lookup("snake")
+	if !ok {
+		return nil
+	}
+	parts := strings.Split(value, ",")
+	if parts[0] != "" {
+		verbosef("%s: name %q of snake tag is ignored, only options are used", name, parts[0])
+	}
+	var options []string
+	for _, option := range parts[1:] {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// withoutTag returns the tag literal tagValue without the key tag.
+func withoutTag(tagValue string, key string) string {
+	tags := tagParser(unquoteTag(tagValue))
+	if _, ok := tags.Lookup(key); !ok {
+		return tagValue
+	}
+	tags.Delete(key)
+	return quoteTag(tagString(tags))
+}
+
+// hasRequiredTag reports whether the source tag tagValue has the tag of
+// -require-tag, e.g. api:"public" or api:"public,readonly" for api:public.
+func hasRequiredTag(tagValue string) bool {
+	key, want := splitRequireTag()
+	value, ok := tagParser(unquoteTag(tagValue)).Lookup(key)
+	return ok && contains(strings.Split(value, ","), want)
+}
+
+// splitRequireTag returns the key and value of -require-tag.
+func splitRequireTag() (key, value string) {
+	i := strings.Index(*requireTag, ":")
+	if i < 0 {
+		return *requireTag, ""
+	}
+	return (*requireTag)[:i], (*requireTag)[i+1:]
+}
+
+// nullValueType returns the type of the generated field for a field of
+// the -null-type type expr, or nil if expr is another type. For a generic
+// Optional[T] it is *T, and interface{} otherwise.
+func nullValueType(expr ast.Expr) ast.Expr {
+	if index, ok := expr.(*ast.IndexExpr); ok && types.ExprString(index.X) == *nullType {
+		return &ast.StarExpr{X: index.Index}
+	}
+	if types.ExprString(expr) == *nullType {
+		return &ast.InterfaceType{Methods: &ast.FieldList{}}
+	}
+	return nil
+}
+
+// parseTypeMap parses the comma-separated SourceType=GeneratedType pairs
+// of -type-map into mappedTypes.
+func parseTypeMap(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return fmt.Errorf("%q is not a SourceType=GeneratedType pair", pair)
+		}
+		from, err := parser.ParseExpr(strings.TrimSpace(pair[:i]))
+		if err != nil {
+			return fmt.Errorf("%q: %s", pair[:i], err)
+		}
+		to, err := parser.ParseExpr(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return fmt.Errorf("%q: %s", pair[i+1:], err)
+		}
+		mappedTypes[types.ExprString(from)] = to
+	}
+	return nil
+}
+
+// fieldTypeNames returns the named type and the identifiers, such as package
+// names and type names, that the types of its fields use.
+func fieldTypeNames(name string, fields []Field) map[string]bool {
+	used := map[string]bool{name: true}
+	for _, f := range fields {
+		ast.Inspect(f.Type, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				used[ident.Name] = true
+			}
+			return true
+		})
+	}
+	return used
+}
+
+// localName returns name, or name followed by a number if used has it.
+func localName(name string, used map[string]bool) string {
+	local := name
+	for i := 2; used[local]; i++ {
+		local = fmt.Sprintf("%s%d", name, i)
+	}
+	return local
+}
+
+// checkFieldType logs, under -v, how a field of a type whose values
+// encoding/json can't marshal statically is handled. Such fields are
+// still generated so that no field is lost silently.
+func (g *Generator) checkFieldType(typeName string, fieldName string, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if m, ok := n.(*ast.MapType); ok && !g.isObjectKey(m.Key) {
+			verbosef("%s.%s: encoding/json cannot marshal %s keys as object keys", typeName, fieldName, types.ExprString(m.Key))
+		}
+		return true
+	})
+	if t := g.pkg.typeOf(expr); t != nil {
+		if _, ok := t.(*types.Named); ok {
+			if hasMethod(t, "MarshalJSON") || hasMethod(t, "MarshalText") {
+				return
+			}
+			switch t.Underlying().(type) {
+			case *types.Signature, *types.Chan:
+				verbosef("%s.%s: %s is copied as is, but its underlying type is %s, which encoding/json cannot marshal", typeName, fieldName, types.ExprString(expr), t.Underlying())
+			}
+			return
+		}
+	}
+	switch t := expr.(type) {
+	case *ast.InterfaceType:
+		if len(t.Methods.List) == 0 {
+			return
+		}
+		verbosef("%s.%s: anonymous interface type is copied as is and marshalled by its dynamic value", typeName, fieldName)
+	case *ast.FuncType, *ast.ChanType:
+		verbosef("%s.%s: %s is copied as is, but encoding/json cannot marshal it", typeName, fieldName, types.ExprString(expr))
+	}
+}
+
+// isObjectKey reports whether encoding/json may marshal map keys of the
+// type expr: strings, integers and encoding.TextMarshalers. Named types
+// are assumed to be keys unless -type-check resolves them.
+func (g *Generator) isObjectKey(expr ast.Expr) bool {
+	if t := g.pkg.typeOf(expr); t != nil {
+		if hasMethod(t, "MarshalText") {
+			return true
+		}
+		basic, ok := t.Underlying().(*types.Basic)
+		return ok && basic.Info()&(types.IsString|types.IsInteger) != 0
+	}
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "bool", "float32", "float64", "complex64", "complex128":
+			return false
+		}
+		return true
+	case *ast.SelectorExpr:
+		return true
+	}
+	return false
+}
+
+// hasMethod reports whether values of type t have the named method,
+// declared or promoted.
+func hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, false, nil, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+// directives returns the comments of group that are directives to tools,
+// such as //nolint:errcheck or //go:generate, or markers such as
+// // +optional for Kubernetes code generators, as opposed to prose.
+// The generated fields keep them so that these tools treat them alike.
+func directives(group *ast.CommentGroup) []string {
+	if group == nil {
+		return nil
+	}
+	var lines []string
+	for _, c := range group.List {
+		if isDirective(c.Text) || isMarker(c.Text) {
+			lines = append(lines, c.Text)
+		}
+	}
+	return lines
+}
+
+// isDirective reports whether the comment c is a directive as go/ast
+// recognizes them, //word: followed by a letter or digit such as
+// //go:generate or //nolint:errcheck, or a bare //nolint. Other comments
+// without a space after the slashes, such as //the name, are prose.
+func isDirective(c string) bool {
+	if c == "//nolint" || strings.HasPrefix(c, "//nolint ") {
+		return true
+	}
+	text := strings.TrimPrefix(c, "//")
+	colon := strings.Index(text, ":")
+	if text == c || colon <= 0 || colon+1 == len(text) || !isDirectiveChar(text[colon+1]) {
+		return false
+	}
+	for i := 0; i < colon; i++ {
+		if !isDirectiveChar(text[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDirectiveChar reports whether c may appear in the word of a directive.
+func isDirectiveChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || isDigit(c)
+}
+
+// isMarker reports whether the comment c is a marker such as // +optional
+// or // +kubebuilder:validation:MaxLength=64: a plus sign followed by a
+// letter.
+func isMarker(c string) bool {
+	rest := strings.TrimPrefix(c, "// +")
+	return rest != c && rest != "" && unicode.IsLetter(rune(rest[0]))
+}
+
+// addTag sets the key tag of tagValue to the snake case form of fieldName.
+// An explicit name in the source tag is kept, and options given without
+// a name are appended to the generated name.
+// The given options are added unless the tag has them already or skips the field.
+func addTag(key string, fieldName string, tagValue string, options ...string) string {
+	tags := tagParser(unquoteTag(tagValue))
+	excludeTags(&tags, key)
+	// An empty name, as in `json:""` or `json:",omitempty"`, means the
+	// default name, which is replaced by the snake case one.
+	value, _ := tags.Lookup(key)
+	explicit := value != "" && !strings.HasPrefix(value, ",")
+	if !explicit {
+		value = fieldKey(fieldName) + value
+	}
+	// Only a bare "-" skips the field; "-," names it "-".
+	if *keyPrefix != "" && value != "-" && (!explicit || *forceRename) {
+		value = *keyPrefix + "_" + value
+	}
+	if value != "-" {
+		have := strings.Split(value, ",")[1:]
+		for _, option := range options {
+			if !contains(have, option) {
+				if !strings.HasSuffix(value, ",") {
+					value += ","
+				}
+				value += option
+			}
+		}
+	}
+	tags.Set(key, value)
+	return quoteTag(tagString(tags))
+}
+
+// fieldKey returns the generated key of the named field.
+func fieldKey(fieldName string) string {
+	if rest := strings.TrimPrefix(fieldName, *stripFieldPrefix); *stripFieldPrefix != "" && rest != fieldName {
+		// Only strip whole words: "DBUserName" loses "DB", "DBase" doesn't.
+		if r, _ := utf8.DecodeRuneInString(rest); unicode.IsUpper(r) {
+			fieldName = rest
+		}
+	}
+	if *nameCmd != "" {
+		return commandKey(fieldName)
+	}
+	return styles[*style](fieldName)
+}
+
+// styles maps the -style names to the conversions of field names to keys.
+var styles = map[string]func(string) string{
+	"snake":                    CamelToSnake,
+	"camelPreserveInitialisms": CamelPreserveInitialisms,
+	"camelLower":               CamelLower,
+	"proto":                    ProtoJSONName,
+}
+
+// tagName returns the name given by the key tag in tagValue.
+func tagName(tagValue string, key string) string {
+	value, _ := tagParser(unquoteTag(tagValue)).Lookup(key)
+	return strings.Split(value, ",")[0]
+}
+
+// isExcludedFile reports whether the file name matches one of the
+// comma-separated glob patterns of -exclude-files.
+func isExcludedFile(name string) (bool, error) {
+	if *excludeFiles == "" {
+		return false, nil
+	}
+	for _, pattern := range strings.Split(*excludeFiles, ",") {
+		matched, err := filepath.Match(strings.TrimSpace(pattern), name)
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+// alsoTags returns the tag keys listed in -also-tag.
+func alsoTags() []string {
+	var keys []string
+	for _, key := range strings.Split(*alsoTag, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// excludeTags deletes the keys listed in -exclude-tag from tags, apart from
+// key itself which is always generated.
+func excludeTags(tags *structTag, key string) {
+	if *excludeTag == "" {
+		return
+	}
+	for _, k := range strings.Split(*excludeTag, ",") {
+		if k = strings.TrimSpace(k); k != key {
+			tags.Delete(k)
+		}
+	}
+}
+
+// inlineOptions holds the option that makes an embedded struct's fields
+// part of the outer struct, for the tag keys whose encoders don't do so
+// by default. encoding/json promotes embedded fields unless they are named.
+var inlineOptions = map[string]string{
+	"yaml": "inline",
+	"bson": "inline",
+}
+
+// addEmbeddedTag returns the tag for an embedded field. No name is generated
+// for it, so that its fields keep being promoted into the outer object.
+func addEmbeddedTag(key string, tagValue string) string {
+	tags := tagParser(unquoteTag(tagValue))
+	excludeTags(&tags, key)
+	if option, ok := inlineOptions[key]; ok {
+		if _, ok := tags.Lookup(key); !ok {
+			tags.Set(key, ","+option)
+		}
+	}
+	return quoteTag(tagString(tags))
+}
+
+// unquoteTag returns the content of a tag literal as found in the source,
+// raw or interpreted. An empty or malformed literal is treated as no tag.
+func unquoteTag(tagValue string) string {
+	value, err := strconv.Unquote(tagValue)
+	if err != nil {
+		return ""
+	}
+	return value
+}
+
+// quoteTag returns tagValue as a string literal, raw unless it contains a
+// backquote, or "" if it is empty.
+func quoteTag(tagValue string) string {
+	if tagValue == "" {
+		return ""
+	}
+	if strings.Contains(tagValue, "`") {
+		return strconv.Quote(tagValue)
+	}
+	return fmt.Sprintf("`%s`", tagValue)
+}
+
+// embeddedFieldName returns the implicit field name of an embedded field type.
+func embeddedFieldName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedFieldName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.Ident:
+		return t.Name
+	}
+	return types.ExprString(expr)
+}
+
+// format returns the gofmt-ed contents of the Generator's buffer.
+func (g *Generator) format() ([]byte, error) {
+	return format.Source(g.buf.Bytes())
+}
+
+type Package struct {
+	dir   string
+	name  string
+	files []File
+	info  *types.Info // with -type-check
+
+	ignored []string // files excluded by build constraints, for diagnostics
+}
+
+// parseFiles parses the package's files concurrently, with at most
+// GOMAXPROCS workers. Results are stored by index, so the file order
+// is unchanged, and the error of the first failing file in that order
+// is returned.
+func (pkg *Package) parseFiles(fs *token.FileSet) error {
+	errs := make([]error, len(pkg.files))
+	indexes := make(chan int)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pkg.files) {
+		workers = len(pkg.files)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				pkg.files[i].AstFile, errs[i] = parser.ParseFile(fs, pkg.files[i].Name, nil, parser.ParseComments)
+			}
+		}()
+	}
+	for i := range pkg.files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("%s: %s", pkg.files[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// declares reports whether name is declared at package level in any of the files.
+func (pkg *Package) declares(name string) bool {
+	for _, file := range pkg.files {
+		if file.AstFile != nil && file.AstFile.Scope.Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredKind returns the kind of the package-level object of the given
+// name, such as ast.Fun, or ast.Bad if there is none.
+func (pkg *Package) declaredKind(name string) ast.ObjKind {
+	for _, file := range pkg.files {
+		if obj := file.AstFile.Scope.Lookup(name); obj != nil {
+			return obj.Kind
+		}
+	}
+	return ast.Bad
+}
+
+// ignoredDeclaring returns the first of the files excluded from the
+// package that declares a type of the given name, or "" if none does.
+func (pkg *Package) ignoredDeclaring(name string) string {
+	for _, file := range pkg.ignored {
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			continue
+		}
+		if obj := f.Scope.Lookup(name); obj != nil && obj.Kind == ast.Typ {
+			return file
+		}
+	}
+	return ""
+}
+
+// docFile returns the name of the first non-test file of the package with
+// a package comment, or "" if there is none.
+func (pkg *Package) docFile() string {
+	for _, file := range pkg.files {
+		if file.AstFile.Doc != nil && !strings.HasSuffix(file.Name, "_test.go") {
+			return file.Name
+		}
+	}
+	return ""
+}
+
+// constrainedFiles returns the files of names, excluded from the package
+// by their build constraints or file names, that belong to the package
+// for -ignore-build-constraints. Files of other packages, such as
+// "package main" programs behind //go:build ignore, are left out. If the
+// package has no other files, it is named after the first one.
+func (pkg *Package) constrainedFiles(names []string) []string {
+	var files []string
+	for _, name := range names {
+		f, err := parser.ParseFile(token.NewFileSet(), prefixDirectory(pkg.dir, name), nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		if pkg.name == "" {
+			pkg.name = f.Name.Name
+		}
+		if f.Name.Name != pkg.name {
+			verbosef("%s belongs to package %s, skipping", name, f.Name.Name)
+			continue
+		}
+		files = append(files, name)
+	}
+	return files
+}
+
+type File struct {
+	Name    string
+	AstFile *ast.File
+}
+
+// Exit codes of the categories of errors, for scripts.
+const (
+	exitOther    = 1 // other errors, such as invalid generated code under -strict or type errors of -check-output
+	exitUsage    = 2 // invalid flags or arguments
+	exitParse    = 3 // the package can't be read or parsed
+	exitNotFound = 4 // a type to generate isn't declared in the package
+	exitWrite    = 5 // the output can't be read, updated or written
+)
+
+// exitf logs like log.Fatalf, exiting with the given code.
+func exitf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// warnf logs a warning unless -quiet is set.
+func warnf(format string, args ...interface{}) {
+	if !*quiet {
+		log.Printf("warning: "+format, args...)
+	}
+}
+
+// verbosef logs a diagnostic when -v is set.
+func verbosef(format string, args ...interface{}) {
+	if *verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// generatedHeader matches the comment marking a file as generated, see
+// https://golang.org/s/generatedcode. Earlier versions of this tool
+// omitted the final period.
+var generatedHeader = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.?$`)
+
+// ownHeader matches the generated code header written by this tool.
+var ownHeader = regexp.MustCompile(`^// Code generated by "json_snake_case.*"; DO NOT EDIT\.?$`)
+
+// isGeneratedFile reports whether the named Go file has a generated code
+// header matched by header before its package clause.
+func isGeneratedFile(name string, header *regexp.Regexp) (bool, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if header.MatchString(line) {
+			return true, nil
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return false, nil
+}
+
+// printNumbered writes src to w with each line prefixed by its number.
+func printNumbered(w io.Writer, src []byte) {
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	width := len(strconv.Itoa(len(lines)))
+	for i, line := range lines {
+		fmt.Fprintf(w, "%*d  %s\n", width, i+1, line)
+	}
+}
+
+// sourcePos returns the file and line of pos, with the file relative to
+// the directory of the output file so that it doesn't depend on where
+// the tool runs.
+func sourcePos(pos token.Position, outputName string) string {
+	file, err := filepath.Rel(filepath.Dir(outputName), pos.Filename)
+	if err != nil {
+		file = filepath.Base(pos.Filename)
+	}
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(file), pos.Line)
+}
+
+// isDirectory reports whether the named file is a directory.
+func isDirectory(name string) bool {
+	info, err := os.Stat(name)
+	if err != nil {
+		exitf(exitUsage, "%s", err)
+	}
+	return info.IsDir()
+}
+
+func prefixDirectory(directory string, name string) string {
+	if directory == "." {
+		return name
+	}
+	return filepath.Join(directory, name)
+}
+
+// utils
+
+// isBuildableName reports whether a Go file named name is included in
+// the build of its package on every platform, i.e. is not ignored for
+// starting with "." or "_" and has no _test, GOOS or GOARCH suffix.
+func isBuildableName(name string) bool {
+	if strings.HasSuffix(name, "_test.go") {
+		return false
+	}
+	for _, platform := range [][2]string{{"linux", "amd64"}, {"windows", "arm64"}} {
+		ctxt := build.Default
+		ctxt.GOOS, ctxt.GOARCH = platform[0], platform[1]
+		ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("package p\n")), nil
+		}
+		if ok, err := ctxt.MatchFile(".", name); !ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lowerFirst returns name with its first letter in lower case, e.g. for
+// unexported declarations named after a type.
+func lowerFirst(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(r)) + name[size:]
+}
+
+// isVariant reports whether name can be used in a file name and as a build tag.
+func isVariant(name string) bool {
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// boolOrString is a flag value that is either given alone like a boolean
+// flag (-name) or with a value (-name=value).
+type boolOrString struct {
+	set   bool
+	value string
+}
+
+func (v *boolOrString) String() string {
+	return v.value
+}
+
+func (v *boolOrString) Set(s string) error {
+	switch s {
+	case "true":
+		v.set, v.value = true, ""
+	case "false":
+		v.set, v.value = false, ""
+	default:
+		v.set, v.value = true, s
+	}
+	return nil
+}
+
+func (v *boolOrString) IsBoolFlag() bool {
+	return true
+}
+
+// includes reports whether the flag is set either alone or with a
+// comma-separated list of values containing name.
+func (v *boolOrString) includes(name string) bool {
+	return v.set && (v.value == "" || contains(strings.Split(v.value, ","), name))
+}
+
+// isTagKey reports whether key can be used as a struct tag key.
+func isTagKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r <= ' ' || r == ':' || r == '"' || r == '`' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, key string) bool {
+	for _, v := range list {
+		if v == key {
+			return true
+		}
+	}
+	return false
+}
+
+// structTag is a parsed struct tag. Keys are kept in source order so
+// that the generated tags are stable and match the source.
+type structTag []tagPair
+
+type tagPair struct {
+	key   string
+	value string
+}
+
+// Lookup returns the value of key and whether it is present.
+func (tags structTag) Lookup(key string) (string, bool) {
+	for _, t := range tags {
+		if t.key == key {
+			return t.value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of key in place, or appends key if it is not present.
+func (tags *structTag) Set(key string, value string) {
+	for i, t := range *tags {
+		if t.key == key {
+			(*tags)[i].value = value
+			return
+		}
+	}
+	*tags = append(*tags, tagPair{key: key, value: value})
+}
+
+// Delete removes key.
+func (tags *structTag) Delete(key string) {
+	kept := (*tags)[:0]
+	for _, t := range *tags {
+		if t.key != key {
+			kept = append(kept, t)
+		}
+	}
+	*tags = kept
+}
+
+// tagParser parses the key:"value" pairs of a struct tag as
+// reflect.StructTag does, keeping their order. Values are unquoted, so
+// they may contain spaces, colons and escaped quotes. A space after the
+// colon is tolerated; parsing stops at anything else malformed.
+func tagParser(input string) structTag {
+	var tags structTag
+	for input != "" {
+		input = strings.TrimLeft(input, " ")
+		i := 0
+		for i < len(input) && input[i] > ' ' && input[i] != ':' && input[i] != '"' && input[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(input) || input[i] != ':' {
+			break
+		}
+		key := input[:i]
+		input = strings.TrimPrefix(input[i+1:], " ")
+		if input == "" || input[0] != '"' {
+			break
+		}
+		i = 1
+		for i < len(input) && input[i] != '"' {
+			if input[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(input) {
+			break
+		}
+		value, err := strconv.Unquote(input[:i+1])
+		if err != nil {
+			break
+		}
+		input = input[i+1:]
+		tags.Set(key, value)
+	}
+	return tags
+}
+
+// tagString formats tags as a struct tag, quoting the values.
+func tagString(tags structTag) string {
+	var pairs []string
+	for _, t := range tags {
+		pairs = append(pairs, t.key+":"+strconv.Quote(t.value))
+	}
+	return strings.Join(pairs, " ")
+}
+
+func CamelToSnake(s string) string {
+	var result string
+	words := splitWords(s)
+	if *snakeNumbers == "separated" {
+		words = separateNumbers(words)
+	}
+	for k, word := range words {
+		if k > 0 {
+			result += "_"
+		}
+		result += strings.ToLower(word)
+	}
+	return result
+}
+
+// CamelPreserveInitialisms returns s in lower camel case with initialisms
+// other than the first word kept in upper case, e.g. "userID" for "UserID".
+func CamelPreserveInitialisms(s string) string {
+	var result string
+	for k, word := range splitWords(s) {
+		if k == 0 {
+			word = strings.ToLower(word)
+		}
+		result += word
+	}
+	return result
+}
+
+// CamelLower returns s in lower camel case with initialisms treated as
+// other words, e.g. "userId" for "UserID" and "httpUrl" for "HTTPURL".
+func CamelLower(s string) string {
+	var result string
+	for k, word := range splitWords(s) {
+		word = strings.ToLower(word)
+		if k > 0 {
+			r, size := utf8.DecodeRuneInString(word)
+			word = string(unicode.ToUpper(r)) + word[size:]
+		}
+		result += word
+	}
+	return result
+}
+
+// ProtoJSONName returns the proto3 JSON name of the field that protoc
+// would generate s for, e.g. "userId" for "UserID" whose proto field is
+// user_id. As in protoc, underscores are dropped and a lower case letter
+// following one is capitalized; other characters are kept.
+func ProtoJSONName(s string) string {
+	var result []rune
+	upper := false
+	for _, r := range CamelToSnake(s) {
+		switch {
+		case r == '_':
+			upper = true
+			continue
+		case upper && 'a' <= r && r <= 'z':
+			r -= 'a' - 'A'
+		}
+		upper = false
+		result = append(result, r)
+	}
+	return string(result)
+}
+
+// splitWords splits the camel case s into its words, keeping each
+// initialism in one word.
+func splitWords(s string) []string {
+	var words []string
+	var lastPos int
+	// Positions are rune indexes, so that field names with non-ASCII
+	// letters are split between runes. Initialisms are ASCII.
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		if i > 0 && unicode.IsUpper(rs[i]) {
+			if initialism := startsWithInitialism(string(rs[lastPos:])); initialism != "" {
+				words = append(words, initialism)
+
+				// The initialism starts the word at lastPos, which
+				// needn't be i-1: "S3Bucket" only splits at the B.
+				lastPos += len(initialism)
+				i = lastPos
+				continue
+			}
+			words = append(words, string(rs[lastPos:i]))
+			lastPos = i
+		}
+	}
+	if lastPos < len(rs) {
+		words = append(words, string(rs[lastPos:]))
+	}
+	// Digits belong to the word before them, as in "Line1", also after
+	// an initialism, as in "HTTP2". A capital letter before digits starts
+	// a word, so that version suffixes stay apart: "APIV2" is "API" + "V2".
+	var grouped []string
+	for _, word := range words {
+		if len(grouped) > 0 && strings.Trim(word, "0123456789") == "" {
+			grouped[len(grouped)-1] += word
+			continue
+		}
+		grouped = append(grouped, word)
+	}
+	return grouped
+}
+
+// separateNumbers splits the digits following letters off words, as in
+// "Line" and "1" for "Line1".
+func separateNumbers(words []string) []string {
+	var separated []string
+	for _, word := range words {
+		last := 0
+		for i := 1; i < len(word); i++ {
+			if isDigit(word[i]) && !isDigit(word[i-1]) {
+				separated = append(separated, word[last:i])
+				last = i
+			}
+		}
+		separated = append(separated, word[last:])
+	}
+	return separated
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// startsWithInitialism returns the initialism if the given string begins with it.
+// An initialism followed by a lower case letter is only used when no shorter
+// one fits, because that letter usually starts the next word
+// (e.g. "HTTPServer" is "HTTP" + "Server", not "HTTPS" + "erver"). A plural
+// s ending a word doesn't, so the longest initialism is kept before it
+// (e.g. "UIDs" is "UID" + "s", not "UI" + "Ds").
+func startsWithInitialism(s string) string {
+	var initialism, fallback string
+	for i := 1; i <= maxInitialismLen && i <= len(s); i++ {
+		if !commonInitialisms[s[:i]] {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(s[i:]); i < len(s) && unicode.IsLower(next) && !isPlural(s[i:]) {
+			fallback = s[:i]
+			continue
 		}
+		initialism = s[:i]
+	}
+	if initialism == "" {
+		return fallback
 	}
 	return initialism
 }
 
+// isPlural reports whether s begins with an s ending a word, i.e. followed
+// by an upper case letter or nothing.
+func isPlural(s string) bool {
+	if !strings.HasPrefix(s, "s") {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(s[1:])
+	return len(s) == 1 || unicode.IsUpper(next)
+}
+
+// loadInitialisms reads the initialisms listed in the named file, one per
+// line, into commonInitialisms, or in place of them if replace is set.
+// Blank lines and lines starting with # are ignored.
+func loadInitialisms(name string, replace bool) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isInitialism(line) {
+			return fmt.Errorf("%s:%d: invalid initialism %q: must be an upper case letter followed by upper case letters and digits", name, i+1, line)
+		}
+		loaded[line] = true
+	}
+	if replace {
+		commonInitialisms = loaded
+		maxInitialismLen = 0
+	}
+	for initialism := range loaded {
+		commonInitialisms[initialism] = true
+		if len(initialism) > maxInitialismLen {
+			maxInitialismLen = len(initialism)
+		}
+	}
+	return nil
+}
+
+// isInitialism reports whether s can be used as an initialism.
+func isInitialism(s string) bool {
+	if len(s) < 2 || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if (s[i] < 'A' || s[i] > 'Z') && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxInitialismLen is the length of the longest of commonInitialisms.
+var maxInitialismLen = 5
+
 // copy from https://github.com/golang/lint
 var commonInitialisms = map[string]bool{
 	"API":   true,
@@ -0,0 +1,1518 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Run as the command itself, for the tests generating code.
+	if os.Getenv("JSON_SNAKE_CASE_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// setFlags resets the flags to their defaults, then parses args as the
+// command line and checks them as main does. They are reset again when
+// the test ends.
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+	resetFlags()
+	t.Cleanup(resetFlags)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	checkFlags()
+}
+
+// builtinInitialisms holds commonInitialisms before -initialisms-file
+// changes them.
+var builtinInitialisms, builtinMaxInitialismLen = copyInitialisms(commonInitialisms), maxInitialismLen
+
+func copyInitialisms(initialisms map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(initialisms))
+	for k, v := range initialisms {
+		c[k] = v
+	}
+	return c
+}
+
+// resetFlags sets the flags of the command, not those of the testing
+// package, and the settings derived from them to their defaults.
+func resetFlags() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	onlyTagged, omitEmpty = boolOrString{}, boolOrString{}
+	commonInitialisms, maxInitialismLen = copyInitialisms(builtinInitialisms), builtinMaxInitialismLen
+}
+
+// writeFiles writes the named files into dir. In their contents, ' stands
+// for a backquote, so that tags can be written in raw string literals.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, src := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(ticks(src)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// ticks replaces ' by backquotes in s.
+func ticks(s string) string {
+	return strings.Replace(s, "'", "`", -1)
+}
+
+// generate writes files into a new package directory and generates the
+// comma-separated types of it with the flags args. It returns the
+// directory and what was logged.
+func generate(t *testing.T, files map[string]string, types string, args ...string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	return dir, generateIn(t, dir, types, args...)
+}
+
+// generateIn runs the command in dir for the comma-separated types with
+// the flags args and returns what was logged. The test fails if the
+// command does.
+func generateIn(t *testing.T, dir string, types string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-type=" + types}, args...)
+	code, _, stderr := runMain(t, dir, args...)
+	if code != 0 {
+		t.Fatalf("json_snake_case %s exits with %d:\n%s", strings.Join(args, " "), code, stderr)
+	}
+	return stderr
+}
+
+// readFile returns the content of the named file of dir.
+func readFile(t *testing.T, dir string, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// vet runs go vet on the package in dir, including the generated code,
+// as a module. It is skipped with -short and if there is no go command.
+func vet(t *testing.T, dir string) {
+	t.Helper()
+	goCommand(t, dir, "vet")
+}
+
+// goTest runs go test on the package in dir like vet, so that tests
+// written into it check the generated code at run time.
+func goTest(t *testing.T, dir string) {
+	t.Helper()
+	goCommand(t, dir, "test")
+}
+
+// goCommand runs the go subcommand on the packages of dir as a module.
+func goCommand(t *testing.T, dir string, subcommand string) {
+	t.Helper()
+	if testing.Short() {
+		return
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Logf("go command not found, skipping go %s", subcommand)
+		return
+	}
+	// Go 1.18 is the first release with any, which test packages use.
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); os.IsNotExist(err) {
+		writeFiles(t, dir, map[string]string{"go.mod": "module example.com/p\n\ngo 1.18\n"})
+	}
+	cmd := exec.Command(goCmd, subcommand, "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("go %s: %s\n%s", subcommand, err, out)
+	}
+}
+
+// runMain runs the command in dir with args and returns its exit code and
+// standard output and error.
+func runMain(t *testing.T, dir string, args ...string) (int, string, string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "JSON_SNAKE_CASE_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	if err, ok := err.(*exec.ExitError); ok {
+		return err.ExitCode(), stdout.String(), stderr.String()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+func TestCamelToSnake(t *testing.T) {
+	for _, tt := range []struct {
+		in, grouped, separated string
+	}{
+		{"Name", "name", "name"},
+		{"UserName", "user_name", "user_name"},
+		{"UserID", "user_id", "user_id"},
+		{"ID", "id", "id"},
+		{"IDToken", "id_token", "id_token"},
+		{"URLPath", "url_path", "url_path"},
+		// Initialisms at the start of a name are split off too.
+		{"URL", "url", "url"},
+		{"IDNumber", "id_number", "id_number"},
+		{"UserIDToken", "user_id_token", "user_id_token"},
+		{"HTTPSURL", "https_url", "https_url"},
+		{"IDURL", "id_url", "id_url"},
+		{"ÜberName", "über_name", "über_name"},
+		{"ÉtéID", "été_id", "été_id"},
+		{"NameÄndern", "name_ändern", "name_ändern"},
+		{"HTTPServer", "http_server", "http_server"},
+		{"HTTPSProxy", "https_proxy", "https_proxy"},
+		{"XMLHttpRequest", "xml_http_request", "xml_http_request"},
+		{"HTTPServerURL", "http_server_url", "http_server_url"},
+		{"ServerID", "server_id", "server_id"},
+		{"ResponseHTML", "response_html", "response_html"},
+		{"EndpointURL", "endpoint_url", "endpoint_url"},
+		{"Address2", "address2", "address_2"},
+		{"Line1", "line1", "line_1"},
+		{"IPv4", "ip_v4", "ip_v_4"},
+		{"S3", "s3", "s_3"},
+		{"S3Bucket", "s3_bucket", "s_3_bucket"},
+		{"HTTP2Server", "http2_server", "http_2_server"},
+		{"APIV2", "api_v2", "api_v_2"},
+		{"OAuth2", "o_auth2", "o_auth_2"},
+		{"Base64", "base64", "base_64"},
+		{"HTTP2", "http2", "http_2"},
+		{"Line10Total", "line10_total", "line_10_total"},
+		{"UIDs", "uid_s", "uid_s"},
+	} {
+		setFlags(t)
+		if got := CamelToSnake(tt.in); got != tt.grouped {
+			t.Errorf("CamelToSnake(%q) = %q, want %q", tt.in, got, tt.grouped)
+		}
+		setFlags(t, "-snake-numbers=separated")
+		if got := CamelToSnake(tt.in); got != tt.separated {
+			t.Errorf("with -snake-numbers=separated, CamelToSnake(%q) = %q, want %q", tt.in, got, tt.separated)
+		}
+	}
+}
+
+func TestInitialismsFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "initialisms.txt")
+	if err := ioutil.WriteFile(name, []byte("# Team initialisms\nSKU\n\n  OAUTH2  \nUNICODE\nS3\nE2E\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		in, merge, replace string
+	}{
+		{"SKUCode", "sku_code", "sku_code"},
+		{"OAUTH2Token", "oauth2_token", "oauth2_token"},
+		{"UNICODEName", "unicode_name", "unicode_name"},
+		{"UserID", "user_id", "user_i_d"},
+		{"HTTPServer", "http_server", "h_t_t_p_server"},
+		{"UserName", "user_name", "user_name"},
+		{"S3Bucket", "s3_bucket", "s3_bucket"},
+		{"BackupS3Bucket", "backup_s3_bucket", "backup_s3_bucket"},
+		{"E2ETest", "e2e_test", "e2e_test"},
+	} {
+		setFlags(t, "-initialisms-file="+name)
+		if got := CamelToSnake(tt.in); got != tt.merge {
+			t.Errorf("-initialisms-mode=merge: CamelToSnake(%q) = %q, want %q", tt.in, got, tt.merge)
+		}
+		setFlags(t, "-initialisms-file="+name, "-initialisms-mode=replace")
+		if got := CamelToSnake(tt.in); got != tt.replace {
+			t.Errorf("-initialisms-mode=replace: CamelToSnake(%q) = %q, want %q", tt.in, got, tt.replace)
+		}
+	}
+	// The built-in initialisms are restored.
+	setFlags(t)
+	if got := CamelToSnake("SKUCode"); got != "s_k_u_code" {
+		t.Errorf("CamelToSnake(%q) = %q after resetting the flags", "SKUCode", got)
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"name", []string{"name"}},
+		{"UserName", []string{"User", "Name"}},
+		{"UserID", []string{"User", "ID"}},
+		{"ID", []string{"ID"}},
+		{"URL", []string{"URL"}},
+		{"IDToken", []string{"ID", "Token"}},
+		{"URLPath", []string{"URL", "Path"}},
+		{"IDURL", []string{"ID", "URL"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"HTTPSProxy", []string{"HTTPS", "Proxy"}},
+		{"XMLHttpRequest", []string{"XML", "Http", "Request"}},
+		{"HTTPURL", []string{"HTTP", "URL"}},
+		{"ServerID", []string{"Server", "ID"}},
+		{"ResponseHTML", []string{"Response", "HTML"}},
+		{"EndpointURL", []string{"Endpoint", "URL"}},
+		{"Line1", []string{"Line1"}},
+		{"HTTP2Server", []string{"HTTP2", "Server"}},
+		{"IPv4", []string{"IP", "v4"}},
+		{"ÜberName", []string{"Über", "Name"}},
+		{"UIDs", []string{"UID", "s"}},
+		{"UIDsByName", []string{"UID", "s", "By", "Name"}},
+		{"IDs", []string{"ID", "s"}},
+		{"UIState", []string{"UI", "State"}},
+	} {
+		if got := splitWords(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestStyles(t *testing.T) {
+	for _, tt := range []struct {
+		in                                                 string
+		snake, camelPreserveInitialisms, camelLower, proto string
+	}{
+		{"Name", "name", "name", "name", "name"},
+		{"UserName", "user_name", "userName", "userName", "userName"},
+		{"UserID", "user_id", "userID", "userId", "userId"},
+		{"ID", "id", "id", "id", "id"},
+		{"HTTPServer", "http_server", "httpServer", "httpServer", "httpServer"},
+		{"HTTPURL", "http_url", "httpURL", "httpUrl", "httpUrl"},
+		{"URLPath", "url_path", "urlPath", "urlPath", "urlPath"},
+		{"ServerIDToken", "server_id_token", "serverIDToken", "serverIdToken", "serverIdToken"},
+		{"HTTP2Port", "http2_port", "http2Port", "http2Port", "http2Port"},
+		{"NameÜber", "name_über", "nameÜber", "nameÜber", "nameüber"},
+	} {
+		for style, want := range map[string]string{
+			"snake":                    tt.snake,
+			"camelPreserveInitialisms": tt.camelPreserveInitialisms,
+			"camelLower":               tt.camelLower,
+			"proto":                    tt.proto,
+		} {
+			if got := styles[style](tt.in); got != want {
+				t.Errorf("-style=%s: %q is %q, want %q", style, tt.in, got, want)
+			}
+		}
+	}
+}
+
+// longTag carries seven keys whose values use semicolons, spaces,
+// colons, parentheses and an escaped quote.
+const longTag = `gorm:"column:user_name;type:varchar(255);not null" validate:"required,min=1" db:"user_name" yaml:"name" xml:"name,attr" example:"say \"hi\"" bson:"name,omitempty"`
+
+func TestTagParser(t *testing.T) {
+	tags := tagParser(longTag)
+	if got := tagString(tags); got != longTag {
+		t.Errorf("tagString(tagParser(longTag)) =\n%s\nwant\n%s", got, longTag)
+	}
+	for _, key := range []string{"gorm", "validate", "db", "yaml", "xml", "example", "bson", "json"} {
+		got, gotOK := tags.Lookup(key)
+		want, wantOK := reflect.StructTag(longTag).Lookup(key)
+		if got != want || gotOK != wantOK {
+			t.Errorf("Lookup(%q) = %q, %v, want %q, %v", key, got, gotOK, want, wantOK)
+		}
+	}
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"", ""},
+		{`json: "name"`, `json:"name"`},
+		{`json:"name" bad`, `json:"name"`},
+		{`json:"name" db:unquoted yaml:"n"`, `json:"name"`},
+		{`json:"unterminated`, ""},
+	} {
+		if got := tagString(tagParser(tt.in)); got != tt.out {
+			t.Errorf("tagParser(%q) gives %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestLongTag(t *testing.T) {
+	dir, _ := generate(t, map[string]string{"p.go": "package p\n\ntype User struct {\n\tUserName string '" + longTag + "'\n}\n"}, "User")
+	first := readFile(t, dir, "user_json.go")
+	if want := "UserName string `" + longTag + ` json:"user_name"` + "`"; !strings.Contains(first, want) {
+		t.Errorf("lacks\n%s\n%s", want, first)
+	}
+	generateIn(t, dir, "User")
+	if got := readFile(t, dir, "user_json.go"); got != first {
+		t.Errorf("second run differs:\n%s\nfirst:\n%s", got, first)
+	}
+	vet(t, dir)
+}
+
+func TestNameCmd(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh:", err)
+	}
+	dir, _ := generate(t, map[string]string{
+		"p.go": `package p
+type User struct {
+	UserID int
+	Nick   string 'json:"nick_name"'
+	Inner  struct{ UserID int }
+}
+`,
+		"lower.sh": "read name\necho \"$name\" >>calls.log\necho \"$name\" | tr A-Z a-z\n",
+	}, "User", "-name-cmd=sh lower.sh")
+	got := readFile(t, dir, "user_json.go")
+	for _, want := range []string{"UserID int `json:\"userid\"`", "Nick string `json:\"nick_name\"`", "Inner struct {"} {
+		if !strings.Contains(collapse(got), want) {
+			t.Errorf("lacks %q:\n%s", want, got)
+		}
+	}
+	if calls := readFile(t, dir, "calls.log"); calls != "UserID\nInner\n" {
+		t.Errorf("called for\n%s", calls)
+	}
+
+	writeFiles(t, dir, map[string]string{"fail.sh": "exit 3\n", "comma.sh": "echo a,b\n"})
+	for _, tt := range []struct {
+		cmd  string
+		code int
+		want string
+	}{
+		{"sh fail.sh", exitOther, "-name-cmd for UserID: exit status 3"},
+		{"sh comma.sh", exitOther, `-name-cmd for UserID: invalid key "a,b"`},
+		{"json_snake_case_missing_command", exitUsage, `invalid -name-cmd "json_snake_case_missing_command"`},
+		{" ", exitUsage, "must name a command"},
+	} {
+		if code, _, stderr := runMain(t, dir, "-type=User", "-name-cmd="+tt.cmd); code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("-name-cmd=%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.cmd, code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestQuoteTag(t *testing.T) {
+	for _, tt := range []struct {
+		literal, value string
+	}{
+		{"``", ""},
+		{"`json:\"name\"`", `json:"name"`},
+		{`"json:\"name\""`, `json:"name"`},
+		{"`", ""},
+		{`"`, ""},
+		{"", ""},
+	} {
+		if got := unquoteTag(tt.literal); got != tt.value {
+			t.Errorf("unquoteTag(%q) = %q, want %q", tt.literal, got, tt.value)
+		}
+	}
+	for _, tt := range []struct {
+		value, literal string
+	}{
+		{"", ""},
+		{`json:"name"`, "`json:\"name\"`"},
+		{"doc:\"a `b`\"", `"doc:\"a ` + "`b`" + `\""`},
+	} {
+		if got := quoteTag(tt.value); got != tt.literal {
+			t.Errorf("quoteTag(%q) = %s, want %s", tt.value, got, tt.literal)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runMain(t, t.TempDir())
+	if code != exitUsage {
+		t.Errorf("exit code %d without -type, want %d", code, exitUsage)
+	}
+	for _, want := range []string{"Usage of ", "[flags] -type T [directory]", "-output string", "Examples:", "-type=User,Order ./models"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("usage lacks %q:\n%s", want, stderr)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": "package p\n\nconst Max = 1\n\ntype User struct{ Name string }\n", "lower.txt": "Sku\n"})
+	empty := t.TempDir()
+	writeFiles(t, empty, map[string]string{"p.go": "// Code generated by \"json_snake_case -type=User\"; DO NOT EDIT.\n\npackage p\n"})
+	for _, tt := range []struct {
+		dir  string
+		args []string
+		code int
+		want string
+	}{
+		{dir, []string{"-type=User"}, 0, ""},
+		{dir, nil, exitUsage, "Usage of"},
+		{dir, []string{"-type=User", "-tag=a:b"}, exitUsage, "invalid -tag"},
+		{dir, []string{"-type=User", "-indent=x"}, exitUsage, "invalid -indent"},
+		{dir, []string{"-type=User", "-variant=a-b"}, exitUsage, "invalid -variant"},
+		{dir, []string{"-type=User", "-variant=test"}, exitUsage, `invalid -variant "test": file names ending in it would be left out of some builds`},
+		{dir, []string{"-type=User", "-variant=linux"}, exitUsage, `invalid -variant "linux": file names ending in it would be left out of some builds`},
+		{dir, []string{"-type=User", "-variant=linux_amd64"}, exitUsage, `invalid -variant "linux_amd64"`},
+		{dir, []string{"-type=User", "-snake-numbers=split"}, exitUsage, "invalid -snake-numbers"},
+		{dir, []string{"-type=User", "-type-map=decimal.Decimal"}, exitUsage, "invalid -type-map"},
+		{dir, []string{"-type=User", "-max-depth=-1"}, exitUsage, "invalid -max-depth"},
+		{dir, []string{"-type=User", "-warn-fields=-1"}, exitUsage, "invalid -warn-fields"},
+		{dir, []string{"-type=User", "-initialisms-mode=extend"}, exitUsage, "invalid -initialisms-mode"},
+		{dir, []string{"-type=User", "-initialisms-file=lower.txt"}, exitUsage, `lower.txt:1: invalid initialism "Sku"`},
+		{dir, []string{"-type=User", "-initialisms-file=missing.txt"}, exitUsage, "reading -initialisms-file: open missing.txt"},
+		{dir, []string{"-type=User", "-since-go-version=2.0"}, exitUsage, "invalid -since-go-version"},
+		{dir, []string{"-type=User", "-style=kebab"}, exitUsage, "invalid -style"},
+		{dir, []string{"-type=User", "-also-tag=json"}, exitUsage, "invalid -also-tag"},
+		{dir, []string{"-type=User", "-tag=json,yaml,json"}, exitUsage, `invalid -also-tag "yaml,json": must list struct tag keys other than json`},
+		{dir, []string{"-type=User", "-schema", "-tag=yaml"}, exitUsage, "-schema describes JSON and requires -tag=json"},
+		{dir, []string{"-type=User", "-build-tag=a &&"}, exitUsage, "invalid -build-tag"},
+		{dir, []string{"-type=User", "-require-tag=api"}, exitUsage, "invalid -require-tag"},
+		{dir, []string{"-type=User", "-require-tag=a b:public"}, exitUsage, "invalid -require-tag"},
+		{empty, []string{"-type=User"}, exitParse, "no Go source files"},
+		{dir, []string{"-type=Order"}, exitNotFound, "type Order not found"},
+		{dir, []string{"-type=Max"}, exitNotFound, "Max is a const, not a type"},
+		{dir, []string{"-type=User", "-output=missing/user_json.go"}, exitWrite, "output directory missing does not exist; create it or pass -mkdir"},
+	} {
+		code, _, stderr := runMain(t, tt.dir, tt.args...)
+		if code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("json_snake_case %s exits with %d, logging\n%s\nwant %d, logging %q", strings.Join(tt.args, " "), code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []File
+	for i := 0; i < 20; i++ {
+		src := fmt.Sprintf("package p\n\ntype T%d struct{ A int }\n", i)
+		if i == 7 || i == 13 {
+			src = "package p\n\ntype broken struct {\n"
+		}
+		name := filepath.Join(dir, fmt.Sprintf("f%02d.go", i))
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, File{Name: name})
+	}
+	pkg := &Package{files: files}
+	err := pkg.parseFiles(token.NewFileSet())
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, "f07.go")+": ") {
+		t.Errorf("got error %v, want the one of f07.go", err)
+	}
+	for i, f := range pkg.files {
+		if i == 7 || i == 13 {
+			continue
+		}
+		if name := f.AstFile.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Name.Name; name != fmt.Sprintf("T%d", i) {
+			t.Errorf("file %d holds %s", i, name)
+		}
+	}
+}
+
+// BenchmarkParseFiles parses a synthetic package of 200 files declaring
+// 20 struct types each, with one worker and with four. The speedup
+// needs as many CPUs: compare with go test -bench ParseFiles -cpu 4.
+func BenchmarkParseFiles(b *testing.B) {
+	dir := b.TempDir()
+	var files []File
+	for i := 0; i < 200; i++ {
+		var src strings.Builder
+		src.WriteString("package p\n")
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&src, "\n// T%d_%d is a generated type.\ntype T%d_%d struct {\n", i, j, i, j)
+			for k := 0; k < 10; k++ {
+				fmt.Fprintf(&src, "\tField%d map[string][]*int `json:\"field_%d,omitempty\" db:\"f%d\"`\n", k, k, k)
+			}
+			src.WriteString("}\n")
+		}
+		name := filepath.Join(dir, fmt.Sprintf("f%d.go", i))
+		if err := ioutil.WriteFile(name, []byte(src.String()), 0644); err != nil {
+			b.Fatal(err)
+		}
+		files = append(files, File{Name: name})
+	}
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(workers))
+			for i := 0; i < b.N; i++ {
+				pkg := &Package{files: append([]File(nil), files...)}
+				if err := pkg.parseFiles(token.NewFileSet()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go":        userIn,
+		"config.json": `{"type": ["User"], "tag": "yaml", "indent": "  ", "only-tagged": true}`,
+	})
+	if code, _, stderr := runMain(t, dir, "-config=config.json", "-tag=json"); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stderr)
+	}
+	got := readFile(t, dir, "user_json.go")
+	for _, want := range []string{"type UserJSON struct", `return json.MarshalIndent(j, "", "  ")`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("config file options not applied, or -tag not taking precedence: lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Email") {
+		t.Errorf("only-tagged not applied:\n%s", got)
+	}
+
+	writeFiles(t, dir, map[string]string{"bad.json": `{"typo": "User"}`})
+	if code, _, stderr := runMain(t, dir, "-config=bad.json"); code != exitUsage || !strings.Contains(stderr, `unknown option "typo"`) {
+		t.Errorf("exit code %d, want %d, logging\n%s", code, exitUsage, stderr)
+	}
+}
+
+func TestNoGoFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"only_test/p_test.go": "package p\n", "empty/doc.txt": "not Go"})
+	for sub, want := range map[string]string{
+		"empty":     "no buildable Go source files in empty",
+		"only_test": "no Go source files in ./only_test",
+	} {
+		code, _, stderr := runMain(t, dir, "-type=User", "./"+sub)
+		if code != exitParse || !strings.Contains(stderr, want) {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", sub, code, stderr, exitParse, want)
+		}
+		if names, _ := filepath.Glob(filepath.Join(dir, sub, "*_json.go")); len(names) != 0 {
+			t.Errorf("%s: wrote %s", sub, names)
+		}
+	}
+}
+
+// TestInlineRegion checks that -inline-region replaces the lines between
+// the markers, keeps the rest of the file byte for byte, and fails
+// without a complete pair of markers.
+func TestInlineRegion(t *testing.T) {
+	const head = `package p
+
+import "encoding/json"
+
+// Decode is hand-written.
+func Decode(b []byte) (User, error) {
+	var u User
+	err := json.Unmarshal(b, &u)
+	return u, err
+}
+
+// json_snake:begin
+`
+	const tail = `// json_snake:end
+
+// Hand-written after the region.
+var _ = json.Valid
+`
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go":   userIn,
+		"api.go": head + "type stale struct{}\n" + tail,
+	})
+	for i := 0; i < 2; i++ {
+		generateIn(t, dir, "User", "-inline-region", "-output=api.go")
+		got := readFile(t, dir, "api.go")
+		if !strings.HasPrefix(got, head) || !strings.HasSuffix(got, tail) {
+			t.Fatalf("run %d: the file outside the region changed:\n%s", i, got)
+		}
+		region := strings.TrimSuffix(strings.TrimPrefix(got, head), tail)
+		if !strings.Contains(region, "type UserJSON struct {") || strings.Contains(region, "stale") || strings.Contains(region, "DO NOT EDIT") {
+			t.Errorf("run %d: region:\n%s", i, region)
+		}
+	}
+	vet(t, dir)
+
+	// Imports the file lacks are added after its package clause.
+	writeFiles(t, dir, map[string]string{"api.go": "package p\n\n// json_snake:begin\n// json_snake:end\n"})
+	generateIn(t, dir, "User", "-inline-region", "-output=api.go")
+	if got := readFile(t, dir, "api.go"); !strings.HasPrefix(got, "package p\n\nimport \"encoding/json\"\n\n// json_snake:begin\n") {
+		t.Errorf("encoding/json not imported:\n%s", got)
+	}
+	vet(t, dir)
+
+	for name, src := range map[string]string{
+		"none.go":      "package p\n",
+		"no_end.go":    "package p\n\n// json_snake:begin\n",
+		"end_first.go": "package p\n\n// json_snake:end\n\n// json_snake:begin\n",
+		"no_begin.go":  "package p\n\n// json_snake:end\n",
+	} {
+		writeFiles(t, dir, map[string]string{name: src})
+		code, _, stderr := runMain(t, dir, "-type=User", "-inline-region", "-output="+name)
+		if code != exitWrite || !strings.Contains(stderr, "missing \"// json_snake:begin\" and \"// json_snake:end\" marker lines") {
+			t.Errorf("%s: exit code %d, logging\n%s", name, code, stderr)
+		}
+		if got := readFile(t, dir, name); got != src {
+			t.Errorf("%s was changed:\n%s", name, got)
+		}
+	}
+}
+
+// TestRerunAll checks that running -type=* again doesn't generate for
+// the types generated by the first run.
+func TestRerunAll(t *testing.T) {
+	dir, _ := generate(t, map[string]string{"p.go": userIn}, "*")
+	first := readFile(t, dir, "p_json.go")
+	logs := generateIn(t, dir, "*")
+	if got := readFile(t, dir, "p_json.go"); got != first || strings.Contains(logs, "UserJSON:") {
+		t.Errorf("second run logs\n%s\nand writes\n%s\nwant\n%s", logs, got, first)
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"p_json.go": "package p\n",
+		"api.go":    "package p\n\ntype Payload struct{ Data string }\n\n// json_snake:begin\n// json_snake:end\n",
+	})
+	generateIn(t, dir, "*", "-inline-region", "-output=api.go")
+	logs = generateIn(t, dir, "*", "-inline-region", "-output=api.go", "-v")
+	got := readFile(t, dir, "api.go")
+	if !strings.Contains(got, "type UserJSON struct") || !strings.Contains(got, "type PayloadJSON struct") || strings.Contains(got, "JSONJSON") {
+		t.Errorf("second -inline-region run:\n%s", got)
+	}
+	if want := "UserJSON is declared in the generated region of"; !strings.Contains(logs, want) {
+		t.Errorf("-v logs lack %q:\n%s", want, logs)
+	}
+	vet(t, dir)
+}
+
+func TestOutputMode(t *testing.T) {
+	files := map[string]string{"p.go": userIn + "\ntype Order struct{ Total int }\n"}
+
+	// skip-existing writes a missing file and keeps an existing one.
+	dir, _ := generate(t, files, "User", "-output-mode=skip-existing")
+	if got := readFile(t, dir, "user_json.go"); !strings.Contains(got, "type UserJSON struct") {
+		t.Errorf("skip-existing without a file:\n%s", got)
+	}
+	writeFiles(t, dir, map[string]string{"user_json.go": "package p\n\n// Tuned by hand.\n"})
+	generateIn(t, dir, "User", "-output-mode=skip-existing")
+	if got := readFile(t, dir, "user_json.go"); got != "package p\n\n// Tuned by hand.\n" {
+		t.Errorf("skip-existing replaced the file:\n%s", got)
+	}
+
+	// overwrite, the default, replaces it.
+	generateIn(t, dir, "User", "-output-mode=overwrite")
+	if got := readFile(t, dir, "user_json.go"); strings.Contains(got, "Tuned by hand") || !strings.Contains(got, "type UserJSON struct") {
+		t.Errorf("overwrite:\n%s", got)
+	}
+
+	// append adds the declarations of another type to it.
+	generateIn(t, dir, "Order", "-output-mode=append", "-output=user_json.go")
+	got := readFile(t, dir, "user_json.go")
+	if strings.Count(got, "DO NOT EDIT") != 1 || strings.Count(got, `import "encoding/json"`) != 1 || !strings.Contains(got, "type UserJSON struct") || !strings.Contains(got, "type OrderJSON struct") {
+		t.Errorf("append:\n%s", got)
+	}
+	vet(t, dir)
+
+	writeFiles(t, dir, map[string]string{"q/other.go": "package q\n"})
+	if code, _, stderr := runMain(t, dir, "-type=Order", "-output-mode=append", "-output=q/other.go"); code != exitWrite || !strings.Contains(stderr, "package q, want p") {
+		t.Errorf("append to another package: exit code %d, logging\n%s", code, stderr)
+	}
+	for _, args := range [][]string{
+		{"-type=User", "-output-mode=replace"},
+		{"-type=User", "-output-mode=append", "-inline-region"},
+	} {
+		if code, _, stderr := runMain(t, dir, args...); code != exitUsage || !strings.Contains(stderr, "-output-mode") {
+			t.Errorf("json_snake_case %s: exit code %d, logging\n%s", strings.Join(args, " "), code, stderr)
+		}
+	}
+}
+
+func TestExactTypeNames(t *testing.T) {
+	dir, _ := generate(t, map[string]string{"p.go": `package p
+
+type User struct{ Name string }
+
+type UserProfile struct {
+	Owner *User
+	Bio   string
+}
+
+type SuperUser struct{ Level int }
+`}, "User")
+	got := readFile(t, dir, "user_json.go")
+	if !strings.Contains(got, "type UserJSON struct") || strings.Contains(got, "UserProfile") || strings.Contains(got, "SuperUser") {
+		t.Errorf("-type=User:\n%s", got)
+	}
+	writeFiles(t, dir, map[string]string{"user_json.go": "package p\n"})
+
+	generateIn(t, dir, "User,UserProfile", "-nested", "-output-mode=merge", "-output=models_json.go")
+	generateIn(t, dir, "User", "-nested", "-output-mode=merge", "-output=models_json.go")
+	got = readFile(t, dir, "models_json.go")
+	for _, want := range []string{"// json_snake:type User\n", "// json_snake:type UserProfile\n", "Owner *UserJSON", "v.Owner = NewUserJSON(m.Owner)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("regenerating User: lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "type UserJSON struct") != 1 || strings.Count(got, "type UserProfileJSON struct") != 1 || strings.Contains(got, "SuperUser") {
+		t.Errorf("regenerating User:\n%s", got)
+	}
+	vet(t, dir)
+}
+
+func TestMergeOutput(t *testing.T) {
+	const order = "\ntype Order struct{ OrderID int }\n"
+	dir, _ := generate(t, map[string]string{"p.go": userIn + order}, "User", "-output-mode=merge", "-output=models_json.go")
+	generateIn(t, dir, "Order", "-output-mode=merge", "-output=models_json.go", "-gen-writer")
+	got := readFile(t, dir, "models_json.go")
+	for _, want := range []string{"// json_snake:type User\n", "// json_snake:type-end User\n", "// json_snake:type Order\n", "type OrderJSON struct", `"io"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("merging Order: lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Count(got, "DO NOT EDIT") != 1 {
+		t.Errorf("merging Order:\n%s", got)
+	}
+	vet(t, dir)
+
+	// Regenerating a type replaces its code only, and drops the imports
+	// only its old code used.
+	writeFiles(t, dir, map[string]string{"p.go": userIn + strings.Replace(order, "OrderID int", "OrderID int; Total int", 1)})
+	generateIn(t, dir, "Order", "-output-mode=merge", "-output=models_json.go")
+	got = readFile(t, dir, "models_json.go")
+	if strings.Count(got, "type OrderJSON struct") != 1 || !strings.Contains(got, `json:"total"`) || strings.Contains(got, `"io"`) || !strings.Contains(got, "type UserJSON struct") {
+		t.Errorf("regenerating Order:\n%s", got)
+	}
+	vet(t, dir)
+
+	writeFiles(t, dir, map[string]string{"plain_json.go": "package p\n", "q/other.go": "package q\n\n// json_snake:type Order\n// json_snake:type-end Order\n"})
+	for _, tt := range []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"-output=plain_json.go"}, exitWrite, `plain_json.go has no "// json_snake:type" markers`},
+		{[]string{"-output=q/other.go"}, exitWrite, "cannot merge to q/other.go: package q, want p"},
+		{[]string{"-output=models_json.go", "-inline-region"}, exitUsage, "-inline-region cannot be combined with -output-mode=merge"},
+		{[]string{"-output=models_json.go", "-template=x.tmpl"}, exitUsage, "-template cannot be combined with -output-mode=merge"},
+	} {
+		args := append([]string{"-type=Order", "-output-mode=merge"}, tt.args...)
+		if code, _, stderr := runMain(t, dir, args...); code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.args, code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"models/user.go": strings.Replace(userIn, "package p", "package models", 1),
+		"sub/doc.go":     "package sub\n",
+	})
+	const outside = "relative to the current directory, outside package directory ./models"
+	for _, tt := range []struct {
+		output  string
+		outside bool
+	}{
+		{"out.go", true},
+		{"sub/out.go", true},
+		{"models/out.go", false},
+		{"./models/../models/out2.go", false},
+	} {
+		logs := generateIn(t, dir, "User", "-v", "-output="+tt.output, "./models")
+		if got := readFile(t, dir, tt.output); !strings.Contains(got, "package models\n") || !strings.Contains(got, "type UserJSON struct") {
+			t.Errorf("-output=%s:\n%s", tt.output, got)
+		}
+		if strings.Contains(logs, outside) != tt.outside {
+			t.Errorf("-output=%s: logs\n%s", tt.output, logs)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "models", "sub", "out.go")); err == nil {
+		t.Error("-output=sub/out.go wrote models/sub/out.go")
+	}
+}
+
+func TestOutputSuffix(t *testing.T) {
+	for _, tt := range []struct {
+		types string
+		args  []string
+		want  string
+	}{
+		{"User", nil, "user_json.go"},
+		{"User", []string{"-tag=yaml"}, "user_yaml.go"},
+		{"User", []string{"-output-suffix=.gen"}, "user.gen.go"},
+		{"User", []string{"-output-suffix=.gen.go"}, "user.gen.go"},
+		{"User", []string{"-output-suffix=_generated"}, "user_generated.go"},
+		{"*", []string{"-output-suffix=_generated"}, "p_generated.go"},
+		{"User", []string{"-output-suffix=.gen", "-variant=gen"}, "user.gen_gen.go"},
+	} {
+		dir, _ := generate(t, map[string]string{"p.go": userIn}, tt.types, tt.args...)
+		if got := readFile(t, dir, tt.want); !strings.Contains(got, "type UserJSON struct") && !strings.Contains(got, "type UserYAML struct") {
+			t.Errorf("%s:\n%s", tt.args, got)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": userIn})
+	for _, suffix := range []string{"_test", "_linux", "_windows", "_amd64", "_linux_arm64", "../x", `a\b`} {
+		code, _, stderr := runMain(t, dir, "-type=User", "-output-suffix="+suffix)
+		if want := "invalid -output-suffix"; code != exitUsage || !strings.Contains(stderr, want) {
+			t.Errorf("-output-suffix=%s: exit code %d, logging\n%s\nwant %d, logging %q", suffix, code, stderr, exitUsage, want)
+		}
+	}
+	if code, _, stderr := runMain(t, dir, "-type=User", "-output-suffix=.gen", "-output=u.go"); code != exitUsage || !strings.Contains(stderr, "-output-suffix cannot be combined with -output") {
+		t.Errorf("-output-suffix with -output: exit code %d, logging\n%s", code, stderr)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	files := map[string]string{"p.go": "package p\n\ntype User struct{ Name string }\n\ntype Order struct{ Total int }\n\ntype Address struct{ City string }\n"}
+	dir, _ := generate(t, files, "User,Order,Address", "-group")
+	got := readFile(t, dir, "user_json.go")
+	const contents = "// Generated types, by name:\n//\n//\tAddressJSON for Address\n//\tOrderJSON for Order\n//\tUserJSON for User\n\n"
+	if !strings.Contains(got, `import "encoding/json"`+"\n\n"+contents+"// AddressJSON is") {
+		t.Errorf("lacks the contents after the imports:\n%s", got)
+	}
+	last := -1
+	for _, name := range []string{"type AddressJSON struct", "type OrderJSON struct", "type UserJSON struct"} {
+		i := strings.Index(got, name)
+		if i < last {
+			t.Errorf("%s is not in order:\n%s", name, got)
+		}
+		last = i
+	}
+	vet(t, dir)
+
+	// The types are generated in the order of -type otherwise.
+	generateIn(t, dir, "User,Order,Address")
+	if got := readFile(t, dir, "user_json.go"); strings.Contains(got, "Generated types") || strings.Index(got, "type UserJSON") > strings.Index(got, "type OrderJSON") {
+		t.Errorf("without -group:\n%s", got)
+	}
+
+	for _, args := range [][]string{
+		{"-type=User", "-group", "-inline-region"},
+		{"-type=User", "-group", "-output-mode=append"},
+		{"-type=User", "-group", "-output-mode=merge"},
+	} {
+		if code, _, stderr := runMain(t, dir, args...); code != exitUsage || !strings.Contains(stderr, "-group cannot be combined") {
+			t.Errorf("json_snake_case %s: exit code %d, logging\n%s", strings.Join(args, " "), code, stderr)
+		}
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"users/user.go":        userIn,
+		"orders/order.go":      "package orders\n\ntype Order struct{ OrderID int }\n",
+		"empty/doc.txt":        "not Go",
+		"testdata/ignored.go":  userIn,
+		"_old/user.go":         userIn,
+		"orders/internal/x.go": "package internal\n\ntype X struct{ A int }\n",
+	})
+	if code, _, stderr := runMain(t, dir, "-type=User,Order", "./..."); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stderr)
+	}
+	if got := readFile(t, dir, "users/user_json.go"); !strings.Contains(got, "type UserJSON struct") {
+		t.Errorf("users/user_json.go:\n%s", got)
+	}
+	if got := readFile(t, dir, "orders/order_json.go"); !strings.Contains(got, "package orders\n") || !strings.Contains(got, "type OrderJSON struct") {
+		t.Errorf("orders/order_json.go:\n%s", got)
+	}
+	for _, name := range []string{"orders/user_json.go", "testdata/user_json.go", "_old/user_json.go", "orders/internal/user_json.go", "orders/internal/order_json.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written", name)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"-type=User,Missing", "-recursive", "."}, exitNotFound, "type Missing not found in any package of ."},
+		{[]string{"-type=User", "-output=all_json.go", "./..."}, exitUsage, "-output cannot be combined with -recursive"},
+	} {
+		if code, _, stderr := runMain(t, dir, tt.args...); code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.args, code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	args := []string{"-nested", "-schema", "-gen-validate", "-gen-partial", "-gen-writer", "-gen-fixture"}
+	dir, _ := generate(t, map[string]string{"p.go": nestedIn}, "*", args...)
+	first := readFile(t, dir, "p_json.go") + readFile(t, dir, "p_json.schema.json")
+	generateIn(t, dir, "*", args...)
+	if second := readFile(t, dir, "p_json.go") + readFile(t, dir, "p_json.schema.json"); second != first {
+		t.Errorf("second run differs:\n%s\nfirst:\n%s", second, first)
+	}
+	header := strings.SplitN(first, "\n", 2)[0]
+	if want := "// Code generated by \"json_snake_case -type=* " + strings.Join(args, " ") + "\"; DO NOT EDIT."; header != want {
+		t.Errorf("header %q, want %q", header, want)
+	}
+	if strings.Contains(first, dir) {
+		t.Errorf("output mentions the directory %s:\n%s", dir, first)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	dir, logs := generate(t, map[string]string{"p.go": userIn + "\ntype Order struct{ Total int }\n"}, "User,Order")
+	for _, want := range []string{"User: 4 fields, 0 skipped\n", "Order: 1 fields, 0 skipped\n", "wrote user_json.go: 2 types, 5 fields, 0 skipped\n"} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("log lacks %q:\n%s", want, logs)
+		}
+	}
+	if logs := generateIn(t, dir, "User,Order", "-quiet"); logs != "" {
+		t.Errorf("-quiet logs\n%s", logs)
+	}
+}
+
+func TestTypeNotFound(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": `package p
+
+type Order struct{ Total int }
+
+const Limit = 10
+
+var Default Order
+
+func Handle(o Order) {}
+
+func f() {
+	// Types declared in functions are not generated.
+	type User struct{ Name string }
+	_ = User{}
+}
+`})
+	for _, tt := range []struct {
+		types, want string
+	}{
+		{"User", "type User not found in .; only types declared at package level are supported"},
+		{"User,Order,Item", "types User, Item not found in .; only types declared at package level are supported"},
+		{"Handle", "Handle is a func, not a type"},
+		{"Limit", "Limit is a const, not a type"},
+		{"Default", "Default is a var, not a type"},
+		{"Handle,User", "type User not found in ."},
+	} {
+		code, _, stderr := runMain(t, dir, "-type="+tt.types)
+		if code != exitNotFound || !strings.Contains(stderr, tt.want) {
+			t.Errorf("-type=%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.types, code, stderr, exitNotFound, tt.want)
+		}
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*_json.go")); len(names) != 0 {
+		t.Errorf("wrote %s", names)
+	}
+}
+
+func TestBuildConstrainedHint(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go": "package p\n",
+		"user.go": `//go:build ignore
+
+package p
+
+type User struct{ Name string }
+`,
+		"order_plan9.go": "package p\n\ntype Order struct{ Total int }\n",
+	})
+	for _, tt := range []struct {
+		types, want string
+	}{
+		{"User", "hint: User is declared in user.go, which is excluded by its build constraints or file name"},
+		{"Order", "hint: Order is declared in order_plan9.go, which is excluded by its build constraints or file name"},
+	} {
+		code, _, stderr := runMain(t, dir, "-type="+tt.types)
+		if code != exitNotFound || !strings.Contains(stderr, tt.want) {
+			t.Errorf("-type=%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.types, code, stderr, exitNotFound, tt.want)
+		}
+	}
+	if _, _, stderr := runMain(t, dir, "-type=Item"); strings.Contains(stderr, "hint:") {
+		t.Errorf("-type=Item: logs\n%s", stderr)
+	}
+}
+
+func TestIgnoreBuildConstraints(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go": "package p\n",
+		"user.go": `//go:build ignore
+
+package p
+
+type User struct{ UserName string }
+`,
+		"gen.go": `//go:build ignore
+
+package main
+
+type Tool struct{ Name string }
+`,
+		"order_plan9.go":   "package p\n\ntype Order struct{ Total int }\n",
+		"order_windows.go": "package p\n\ntype Order struct{ Total int }\n",
+	})
+	code, _, stderr := runMain(t, dir, "-type=User")
+	if want := "-ignore-build-constraints includes it"; code != exitNotFound || !strings.Contains(stderr, want) {
+		t.Errorf("-type=User: exit code %d, logging\n%s\nwant %d, logging %q", code, stderr, exitNotFound, want)
+	}
+
+	logs := generateIn(t, dir, "User,Order", "-ignore-build-constraints", "-v")
+	for _, want := range []string{
+		"warning: User is declared in user.go, which is excluded by its build constraints or file name; the output may need -build-tag",
+		"Order is declared again in order_windows.go, skipping",
+		"gen.go belongs to package main, skipping",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs lack %q:\n%s", want, logs)
+		}
+	}
+	got := readFile(t, dir, "user_json.go")
+	if !strings.Contains(got, "UserName string `json:\"user_name\"`") || strings.Count(got, "type OrderJSON struct") != 1 {
+		t.Errorf("user_json.go:\n%s", got)
+	}
+
+	if logs := generateIn(t, dir, "User", "-ignore-build-constraints", "-build-tag=ignore"); strings.Contains(logs, "warning:") {
+		t.Errorf("-build-tag=ignore: logs\n%s", logs)
+	}
+	if got := readFile(t, dir, "user_json.go"); !strings.Contains(got, "//go:build ignore\n") {
+		t.Errorf("-build-tag=ignore:\n%s", got)
+	}
+
+	// A package of ignored files only is named after them.
+	only := t.TempDir()
+	writeFiles(t, only, map[string]string{"user.go": "//go:build ignore\n\npackage q\n\ntype User struct{ Name string }\n"})
+	generateIn(t, only, "User", "-ignore-build-constraints")
+	if got := readFile(t, only, "user_json.go"); !strings.Contains(got, "package q\n") {
+		t.Errorf("ignored files only:\n%s", got)
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": `package p
+type Level float64
+type User struct {
+	ByID    map[int]string
+	ByName  map[string]int
+	Flags   map[bool]string
+	Scores  map[float64]string
+	Friends map[*User]bool
+	Levels  map[Level]string
+	Nested  []map[bool]int
+}
+`})
+	for _, tt := range []struct {
+		args          []string
+		logs, notLogs []string
+	}{
+		{[]string{"-v"}, []string{
+			"User.Flags: encoding/json cannot marshal bool keys as object keys",
+			"User.Scores: encoding/json cannot marshal float64 keys",
+			"User.Friends: encoding/json cannot marshal *User keys",
+			"User.Nested: encoding/json cannot marshal bool keys",
+		}, []string{"User.ByID:", "User.ByName:", "User.Levels:"}},
+		{[]string{"-v", "-type-check"}, []string{"User.Levels: encoding/json cannot marshal Level keys"}, []string{"User.ByID:"}},
+		{nil, nil, []string{"cannot marshal"}},
+	} {
+		logs := generateIn(t, dir, "User", tt.args...)
+		for _, want := range tt.logs {
+			if !strings.Contains(logs, want) {
+				t.Errorf("%s: logs lack %q:\n%s", tt.args, want, logs)
+			}
+		}
+		for _, notWant := range tt.notLogs {
+			if strings.Contains(logs, notWant) {
+				t.Errorf("%s: logs contain %q:\n%s", tt.args, notWant, logs)
+			}
+		}
+	}
+}
+
+func TestExcludeFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"user.go":        userIn,
+		"order_mock.go":  "package p\n\ntype Order struct{ Total int }\n",
+		"item_legacy.go": "package p\n\ntype Item struct{ SKU string }\n",
+	})
+	generateIn(t, dir, "User,Order", "-exclude-files=*_legacy.go")
+	for _, tt := range []struct {
+		patterns, types string
+		code            int
+		want            string
+	}{
+		{"*_mock.go", "Order", exitNotFound, "type Order not found in ."},
+		{"item_legacy.go, *_mock.go", "Item,Order", exitNotFound, "types Item, Order not found in ."},
+		{"[", "User", exitUsage, `invalid -exclude-files "[": syntax error in pattern`},
+	} {
+		code, _, stderr := runMain(t, dir, "-type="+tt.types, "-exclude-files="+tt.patterns)
+		if code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("-exclude-files=%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.patterns, code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestEditCheck(t *testing.T) {
+	dir := t.TempDir()
+	const warning = "warning: user_json.go lacks the generated code header"
+	for _, tt := range []struct {
+		existing string
+		args     []string
+		warns    bool
+	}{
+		{"package p\n\n// Written by hand.\n", nil, true},
+		{"package p\n\n// Written by hand.\n", []string{"-no-edit-check"}, false},
+		{"// Code generated by \"json_snake_case -type=User\"; DO NOT EDIT\n\npackage p\n", nil, false},
+		{"// Code generated by hand; DO NOT EDIT.\n\npackage p\n", nil, false},
+	} {
+		writeFiles(t, dir, map[string]string{"p.go": userIn, "user_json.go": tt.existing})
+		logs := generateIn(t, dir, "User", tt.args...)
+		if strings.Contains(logs, warning) != tt.warns {
+			t.Errorf("%q with %s: logs\n%s", tt.existing, tt.args, logs)
+		}
+		if got := readFile(t, dir, "user_json.go"); !strings.HasPrefix(got, "// Code generated by \"json_snake_case -type=User") {
+			t.Errorf("%q not overwritten:\n%s", tt.existing, got)
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": userIn, "user_json.go": "package p\n\n// Written by hand.\n"})
+	if logs := generateIn(t, dir, "User", "-quiet"); logs != "" {
+		t.Errorf("-quiet logs\n%s", logs)
+	}
+	code, _, stderr := runMain(t, dir, "-type=Missing", "-quiet")
+	if want := "json_snake: type Missing not found"; code != exitNotFound || !strings.Contains(stderr, want) {
+		t.Errorf("-quiet: exit code %d, logging\n%s\nwant %d, logging %q", code, stderr, exitNotFound, want)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go":       userIn,
+		"parse.tmpl": "{{range .Types}}",
+		"exec.tmpl":  "{{.Missing}}",
+	})
+	for _, tt := range []struct {
+		name string
+		code int
+		want string
+	}{
+		{"parse.tmpl", exitUsage, "reading template: template: parse.tmpl:1: unexpected EOF"},
+		{"exec.tmpl", exitOther, "executing template: template: exec.tmpl:1:2: executing \"exec.tmpl\" at <.Missing>: can't evaluate field Missing"},
+		{"missing.tmpl", exitUsage, "reading template: open missing.tmpl"},
+	} {
+		code, _, stderr := runMain(t, dir, "-type=User", "-template="+tt.name)
+		if code != tt.code || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.name, code, stderr, tt.code, tt.want)
+		}
+	}
+}
+
+func TestBroken(t *testing.T) {
+	dir := t.TempDir()
+	const existing = "package p\n"
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		{nil, 0},
+		{[]string{"-strict"}, exitOther},
+		{[]string{"-quiet"}, exitOther},
+		{[]string{"-strict", "-quiet"}, exitOther},
+	} {
+		writeFiles(t, dir, map[string]string{
+			"p.go":         userIn,
+			"user_json.go": existing,
+			"broken.tmpl":  "{{range .Types}}func {{.Name}}( {{end}}",
+		})
+		os.Remove(filepath.Join(dir, "user_json.go.broken"))
+		args := append([]string{"-type=User", "-template=broken.tmpl"}, tt.args...)
+		code, _, stderr := runMain(t, dir, args...)
+		if code != tt.code || !strings.Contains(stderr, "wrote the unformatted code to user_json.go.broken, user_json.go is unchanged") {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d", tt.args, code, stderr, tt.code)
+		}
+		if got := readFile(t, dir, "user_json.go"); got != existing {
+			t.Errorf("%s: user_json.go overwritten:\n%s", tt.args, got)
+		}
+		if got := readFile(t, dir, "user_json.go.broken"); !strings.Contains(got, "func User(") {
+			t.Errorf("%s: user_json.go.broken:\n%s", tt.args, got)
+		}
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	const existing = "package p\n"
+	for _, tt := range []struct {
+		name, src string
+		args      []string
+		want      string
+	}{
+		{"template", userIn, []string{"-template=undefined.tmpl"}, "user_json.go:5:9: undefined: UndefinedUser"},
+		{"missing import", "package p\n\nimport \"time\"\n\ntype User struct{ CreatedAt time.Time }\n", nil, "user_json.go:9:12: undefined: time"},
+	} {
+		writeFiles(t, dir, map[string]string{
+			"p.go":           tt.src,
+			"user_json.go":   existing,
+			"undefined.tmpl": "{{range .Types}}var _ = Undefined{{.Name}}{{end}}",
+		})
+		args := append([]string{"-type=User", "-check-output"}, tt.args...)
+		code, _, stderr := runMain(t, dir, args...)
+		if code != exitOther || !strings.Contains(stderr, "type error in generated code: "+tt.want) || !strings.Contains(stderr, "wrote the generated code to user_json.go.broken, user_json.go is unchanged") {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.name, code, stderr, exitOther, tt.want)
+		}
+		if got := readFile(t, dir, "user_json.go"); got != existing {
+			t.Errorf("%s: user_json.go overwritten:\n%s", tt.name, got)
+		}
+		if got := readFile(t, dir, "user_json.go.broken"); !strings.Contains(got, "// Code generated by") {
+			t.Errorf("%s: user_json.go.broken:\n%s", tt.name, got)
+		}
+	}
+
+	// Errors in other files are not the output's, and are only logged
+	// with -v.
+	writeFiles(t, dir, map[string]string{"p.go": userIn + "\nvar _ = NewUserJSON\n", "other.go": "package p\n\nvar _ = Missing\n"})
+	logs := generateIn(t, dir, "User", "-check-output", "-nested", "-v")
+	if want := "type-checking: other.go:3:9: undefined: Missing"; !strings.Contains(logs, want) {
+		t.Errorf("logs lack %q:\n%s", want, logs)
+	}
+	if got := readFile(t, dir, "user_json.go"); !strings.Contains(got, "type UserJSON struct") {
+		t.Errorf("user_json.go:\n%s", got)
+	}
+}
+
+func TestPackageDoc(t *testing.T) {
+	dir, _ := generate(t, map[string]string{"p.go": userIn}, "User", "-package-doc=holds the API types.", "-build-tag=gen")
+	// The generated file is skipped when parsing, so running again finds
+	// no other package comment.
+	generateIn(t, dir, "User", "-package-doc=holds the API types.", "-build-tag=gen")
+	got := readFile(t, dir, "user_json.go")
+	if want := "\n\n//go:build gen\n\n// Package p holds the API types.\npackage p\n"; !strings.Contains(got, want) {
+		t.Errorf("lacks %q:\n%s", want, got)
+	}
+	vet(t, dir)
+
+	writeFiles(t, dir, map[string]string{"doc.go": "// Package p is documented.\npackage p\n"})
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "-package-doc: package p already has a package comment in doc.go"},
+		{[]string{"-inline-region", "-output=doc.go"}, "-package-doc cannot be combined with -inline-region"},
+		{[]string{"-output-mode=merge"}, "-package-doc cannot be combined with -inline-region"},
+	} {
+		args := append([]string{"-type=User", "-package-doc=holds the API types."}, tt.args...)
+		if code, _, stderr := runMain(t, dir, args...); code != exitUsage || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%s: exit code %d, logging\n%s\nwant %d, logging %q", tt.args, code, stderr, exitUsage, tt.want)
+		}
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": `package p
+
+type User struct {
+	UserName string   'api:"public"'
+	Password string   'api:"private"'
+	Tags     []string 'api:"public" json:"labels,omitempty"'
+}
+`})
+	code, stdout, stderr := runMain(t, dir, "-type=User", "-plan-json", "-require-tag=api:public")
+	if code != 0 {
+		t.Fatalf("exit code %d, logging\n%s", code, stderr)
+	}
+	var got Plan
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("%s:\n%s", err, stdout)
+	}
+	want := Plan{Package: "p", Output: "user_json.go", Types: []PlanType{{
+		Name:      "User",
+		Generated: "UserJSON",
+		Pos:       "p.go:3",
+		Fields: []PlanField{
+			{Name: "UserName", Type: "string", GeneratedType: "string", Key: "user_name", Tag: `api:"public" json:"user_name"`},
+			{Name: "Tags", Type: "[]string", GeneratedType: "[]string", Key: "labels", Tag: `api:"public" json:"labels,omitempty"`},
+		},
+		Skipped: []Skip{{Name: "Password", Reason: "no api:public tag, with -require-tag"}},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("plan\n%+v\nwant\n%+v", got, want)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "user_json*")); len(names) > 0 {
+		t.Errorf("-plan-json wrote %s", names)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go":        userIn,
+		"broken.tmpl": "{{range .Types}}func {{.Name}}( {{end}}",
+	})
+	for _, tt := range []struct {
+		args []string
+		code int
+		want []string
+	}{
+		{nil, 0, []string{
+			` 1  // Code generated by "json_snake_case -type=User -print"; DO NOT EDIT.` + "\n 2  \n 3  package p\n",
+			"12  \tUserName string  'json:\"name\"'\n",
+		}},
+		{[]string{"-schema"}, 0, []string{" 3  package p\n"}},
+		{[]string{"-template=broken.tmpl"}, 0, []string{"warning: invalid Go generated", "5  func User("}},
+		{[]string{"-template=broken.tmpl", "-strict"}, 1, []string{"5  func User("}},
+	} {
+		args := append([]string{"-type=User", "-print"}, tt.args...)
+		code, stdout, stderr := runMain(t, dir, args...)
+		if code != tt.code || stdout != "" {
+			t.Errorf("%s: exit code %d, printing\n%s\nwant %d, printing nothing", tt.args, code, stdout, tt.code)
+		}
+		for _, want := range tt.want {
+			if want = ticks(want); !strings.Contains(stderr, want) {
+				t.Errorf("%s: standard error lacks %q:\n%s", tt.args, want, stderr)
+			}
+		}
+		if names, _ := filepath.Glob(filepath.Join(dir, "user_json*")); len(names) != 0 {
+			t.Errorf("%s: wrote %s", tt.args, names)
+		}
+	}
+}
+
+func TestSourcePos(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"models/user.go":  "package models\n\n// User is a user.\ntype User struct{ Name string }\n",
+		"models/order.go": "package models\n\ntype (\n\tItem  struct{ SKU string }\n\tOrder struct{ Total int }\n)\n",
+		"gen/doc.go":      "package gen\n",
+	})
+	for _, tt := range []struct {
+		output string
+		want   []string
+	}{
+		{"models/user_json.go", []string{
+			"// UserJSON is the JSON serialization view of User (from user.go:4).\n//\n// User is a user.\n",
+			"// OrderJSON is the JSON serialization view of Order (from order.go:5).\n",
+		}},
+		{"gen/user_json.go", []string{"(from ../models/user.go:4)", "(from ../models/order.go:5)"}},
+	} {
+		generateIn(t, dir, "User,Order", "-source-pos", "-output="+tt.output, "./models")
+		got := readFile(t, dir, tt.output)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s lacks %q:\n%s", tt.output, want, got)
+			}
+		}
+	}
+}
+
+// TestMkdir checks that a missing output directory is an error naming it,
+// and that -mkdir creates it with its parents.
+func TestMkdir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"p.go": userIn})
+	code, _, stderr := runMain(t, dir, "-type=User", "-output=gen/sub/user_json.go")
+	if code != exitWrite || !strings.Contains(stderr, "output directory gen/sub does not exist; create it or pass -mkdir") {
+		t.Errorf("exit %d, log:\n%s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen")); !os.IsNotExist(err) {
+		t.Errorf("gen was created: %v", err)
+	}
+	if code, _, stderr := runMain(t, dir, "-type=User", "-output=gen/sub/user_json.go", "-print"); code != 0 {
+		t.Errorf("-print exits with %d, log:\n%s", code, stderr)
+	}
+
+	logs := generateIn(t, dir, "User", "-output=gen/sub/user_json.go", "-mkdir", "-schema", "-v")
+	if !strings.Contains(logs, "created output directory gen/sub") {
+		t.Errorf("log:\n%s", logs)
+	}
+	for _, name := range []string{"user_json.go", "user_json.schema.json"} {
+		if got := readFile(t, filepath.Join(dir, "gen", "sub"), name); got == "" {
+			t.Errorf("%s is empty", name)
+		}
+	}
+}
+
+// TestRerun checks that running with -type=* again leaves the output as it
+// is, since the types of the output are not generated for.
+func TestRerun(t *testing.T) {
+	dir, _ := generate(t, map[string]string{
+		"p.go":       userIn + "\ntype Order struct{ Total int }\n",
+		"p_proto.go": "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage p\n\ntype Item struct{ SKU string }\n",
+	}, "*")
+	first := readFile(t, dir, "p_json.go")
+	generateIn(t, dir, "*")
+	if got := readFile(t, dir, "p_json.go"); got != first {
+		t.Errorf("second run:\n%s\nfirst run:\n%s", got, first)
+	}
+	if logs := generateIn(t, dir, "*", "-v"); !strings.Contains(logs, "p_json.go was generated by json_snake_case, skipping") {
+		t.Errorf("log:\n%s", logs)
+	}
+	for _, want := range []string{"type UserJSON struct", "type OrderJSON struct", "type ItemJSON struct"} {
+		if !strings.Contains(first, want) {
+			t.Errorf("p_json.go lacks %q:\n%s", want, first)
+		}
+	}
+	vet(t, dir)
+}
+
+// TestTypeCheckOutput checks that -type-check leaves the generated code as
+// it is, even when the package has type errors.
+func TestTypeCheckOutput(t *testing.T) {
+	dir, _ := generate(t, map[string]string{"p.go": typeCheckIn, "use.go": "package p\n\nvar _ = NewUserJSON\n"}, "User")
+	want := readFile(t, dir, "user_json.go")
+	if err := os.Remove(filepath.Join(dir, "user_json.go")); err != nil {
+		t.Fatal(err)
+	}
+	logs := generateIn(t, dir, "User", "-type-check", "-v")
+	got := readFile(t, dir, "user_json.go")
+	if body(got) != body(want) {
+		t.Errorf("with -type-check:\n%s\nwithout:\n%s", got, want)
+	}
+	if !strings.Contains(logs, "undefined: NewUserJSON") {
+		t.Errorf("type error not logged:\n%s", logs)
+	}
+}
+
+// TestBenchUpToDate regenerates the package of the benchmarks in
+// testdata/bench with its go:generate lines and compares the outputs
+// with the files committed there.
+func TestBenchUpToDate(t *testing.T) {
+	models := readFile(t, "testdata/bench", "models.go")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"models.go": models})
+	for _, line := range strings.Split(models, "\n") {
+		if !strings.HasPrefix(line, "//go:generate json_snake_case ") {
+			continue
+		}
+		args := strings.Fields(line)[2:]
+		types := strings.TrimPrefix(args[0], "-type=")
+		output := strings.TrimPrefix(args[len(args)-1], "-output=")
+		args[len(args)-1] = "-output=" + filepath.Join(dir, output)
+		generateIn(t, dir, types, args[1:]...)
+		// The header names the output path, the rest must match byte
+		// for byte.
+		want := readFile(t, "testdata/bench", output)
+		got := readFile(t, dir, output)
+		if body(got) != body(want) {
+			t.Errorf("%s is out of date, run go generate in testdata/bench:\n%s", output, got)
+		}
+	}
+}
+
+// body returns src without its first line.
+func body(src string) string {
+	return src[strings.Index(src, "\n")+1:]
+}
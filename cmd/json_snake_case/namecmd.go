@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commandKeys caches the keys returned by -name-cmd by field name.
+var commandKeys = map[string]string{}
+
+// commandKey returns the key -name-cmd gives the named field. The command,
+// split into words, is run once per distinct name, with the name and a
+// newline on its standard input; its standard output, without surrounding
+// white space, is the key. Its standard error is passed through. A command
+// that can't be started is a usage error; a failing command or an invalid
+// key exits with exitOther.
+func commandKey(fieldName string) string {
+	if key, ok := commandKeys[fieldName]; ok {
+		return key
+	}
+	args := strings.Fields(*nameCmd)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(fieldName + "\n")
+	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		code := exitOther
+		if _, ok := err.(*exec.Error); ok {
+			code = exitUsage
+		}
+		exitf(code, "-name-cmd for %s: %s", fieldName, err)
+	}
+	key := strings.TrimSpace(out.String())
+	if key == "" || strings.ContainsAny(key, ",\n") {
+		exitf(exitOther, "-name-cmd for %s: invalid key %q: must be one non-empty line without commas", fieldName, key)
+	}
+	verbosef("-name-cmd: %s is %s", fieldName, key)
+	commandKeys[fieldName] = key
+	return key
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// isGenerated reports whether -nested converts values of the named type,
+// i.e. whether it is one of the types generated in this run.
+func (g *Generator) isGenerated(name string) bool {
+	if !*nested {
+		return false
+	}
+	for _, t := range g.types {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// needsConversion reports whether values of the type expr contain values
+// of generated types or of anonymous structs, directly or through
+// pointers, arrays, slices and map values. Only type literals are looked
+// into: a named collection type such as "type Nodes []Node" is copied as
+// it is, since its generated counterpart would have to be declared too.
+// Anonymous structs are converted since their fields are given generated
+// tags, which makes them a different type. expr is at the given level of
+// a field type, see withinDepth.
+func (g *Generator) needsConversion(expr ast.Expr, level int) bool {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return g.isGenerated(t.Name) && withinDepth(level)
+	case *ast.StarExpr:
+		return g.needsConversion(t.X, level)
+	case *ast.ArrayType:
+		return g.needsConversion(t.Elt, level+1)
+	case *ast.MapType:
+		return g.needsConversion(t.Value, level+1)
+	case *ast.StructType:
+		return len(t.Fields.List) > 0
+	}
+	return false
+}
+
+// withinDepth reports whether values of generated types at the given level
+// of a field type are converted with -max-depth. The field is at level 1,
+// and slices, arrays, maps and anonymous structs add a level for their
+// elements or fields; pointers don't. Deeper values keep the source type,
+// whose MarshalJSON, generated for json tags, still gives them the
+// generated keys. Anonymous structs are converted at any level, having no
+// MarshalJSON.
+func withinDepth(level int) bool {
+	return *maxDepth == 0 || level <= *maxDepth
+}
+
+// shadowType returns the type expr with each generated type replaced by
+// its generated struct, and the fields of anonymous structs given
+// generated tags. Only the name is substituted, so self-referencing and
+// mutually referencing types are handled like any other.
+func (g *Generator) shadowType(expr ast.Expr) string {
+	return g.typeOf(expr, true, 1)
+}
+
+// structType returns the anonymous struct type t at the given level. If
+// shadow is set, its fields are given generated tags and types as the
+// fields of a generated struct are; having no type name, only a bare
+// -omitempty applies to them.
+func (g *Generator) structType(t *ast.StructType, shadow bool, level int) string {
+	if len(t.Fields.List) == 0 {
+		return "struct{}"
+	}
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, field := range t.Fields.List {
+		tagValue := ""
+		if field.Tag != nil {
+			tagValue = field.Tag.Value
+		}
+		fieldType := g.typeOf(field.Type, shadow, level+1)
+		if len(field.Names) == 0 {
+			if shadow {
+				tagValue = generatedEmbeddedTag(withoutTag(tagValue, "snake"))
+			}
+			fmt.Fprintf(&b, "%s %s\n", fieldType, tagValue)
+			continue
+		}
+		for _, ident := range field.Names {
+			fieldTag := tagValue
+			if shadow {
+				options := tagOptions("", snakeTagOptions(ident.Name, tagValue))
+				fieldTag = generatedTag(ident.Name, withoutTag(tagValue, "snake"), options)
+			}
+			fmt.Fprintf(&b, "%s %s %s\n", ident.Name, fieldType, fieldTag)
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// fieldType returns the type of f in the generated struct.
+func (g *Generator) fieldType(f Field) string {
+	if f.Mapped != nil {
+		return types.ExprString(f.Mapped)
+	}
+	if f.Deref {
+		return g.shadowType(f.Type.(*ast.StarExpr).X)
+	}
+	return g.shadowType(f.Type)
+}
+
+// fieldNeedsConversion reports whether f can't be copied by assignment.
+func (g *Generator) fieldNeedsConversion(f Field) bool {
+	return f.Mapped != nil || f.Deref || g.needsConversion(f.Type, 1)
+}
+
+// newCall returns a call of the constructor of the generated struct of
+// the named type with the given argument, passing on the context with
+// -with-context.
+func (g *Generator) newCall(name string, arg string) string {
+	if *withContext {
+		return fmt.Sprintf("New%s%s(%s, %s)", name, g.suffix, g.ctx, arg)
+	}
+	return fmt.Sprintf("New%s%s(%s)", name, g.suffix, arg)
+}
+
+// shadowName returns the name of f in the generated struct, which differs
+// from the source for embedded fields of generated types.
+func (g *Generator) shadowName(f Field) string {
+	if f.Embedded && g.needsConversion(f.Type, 1) {
+		return f.Name + g.suffix
+	}
+	return f.Name
+}
+
+// generateCopy emits the body of a function returning the composite
+// literal of type literal, with fields copied from the struct src. It copies
+// to the generated struct if toShadow is set, and from it otherwise. If dst
+// is set, the fields are copied to the struct it points to instead, and
+// nothing is returned.
+func (g *Generator) generateCopy(literal string, src string, fields []Field, toShadow bool, dst string) {
+	// Promoted fields can't be set in a composite literal of the source
+	// type, and are assigned after it.
+	assigned := func(f Field) bool {
+		return g.fieldNeedsConversion(f) || f.Promoted && !toShadow
+	}
+	var converted []Field
+	for _, f := range fields {
+		if assigned(f) {
+			converted = append(converted, f)
+		}
+	}
+	used := fieldTypeNames(strings.TrimPrefix(literal, "&"), fields)
+	used[src] = true
+	v := localName("v", used)
+	if dst != "" {
+		v = dst
+		g.Printf("	*%s = %s{\n", dst, literal)
+	} else if len(converted) == 0 {
+		g.Printf("	return %s{\n", literal)
+	} else {
+		g.Printf("	%s := %s{\n", v, literal)
+	}
+	for _, f := range fields {
+		if assigned(f) {
+			continue
+		}
+		g.Printf("		%s:  %s.%s,\n", g.shadowName(f), src, f.Name)
+	}
+	g.Printf("	}\n")
+	if len(converted) == 0 {
+		return
+	}
+	for _, f := range converted {
+		dstName, srcName := f.Name, g.shadowName(f)
+		if toShadow {
+			dstName, srcName = srcName, dstName
+		}
+		dst, src := v+"."+dstName, src+"."+srcName
+		if f.Mapped != nil {
+			// The generated type is meant for output, e.g. a string for a
+			// decimal; there is no general way back.
+			if toShadow && f.Null {
+				g.Printf("if %s.IsSet() {\n", src)
+				if _, ok := f.Mapped.(*ast.StarExpr); ok {
+					x := localName("x", used)
+					g.Printf("%s := %s.Value()\n", x, src)
+					g.Printf("%s = &%s\n", dst, x)
+				} else {
+					g.Printf("%s = %s.Value()\n", dst, src)
+				}
+				g.Printf("}\n")
+			} else if toShadow {
+				g.Printf("%s = %s.String()\n", dst, src)
+			} else {
+				g.Printf("// %s is not converted back from %s.\n", dstName, types.ExprString(f.Mapped))
+			}
+			continue
+		}
+		if !f.Deref {
+			g.convert(dst, src, f.Type, toShadow, 1, 0)
+			continue
+		}
+		// The generated struct holds the value pointed to, or its zero
+		// value for nil. Converting back always yields a non-nil pointer.
+		elem := f.Type.(*ast.StarExpr).X
+		if toShadow {
+			g.Printf("if %s != nil {\n", src)
+			g.convert(dst, "(*"+src+")", elem, toShadow, 1, 1)
+			g.Printf("}\n")
+		} else {
+			g.Printf("%s = new(%s)\n", dst, g.typeOf(elem, false, 1))
+			g.convert("(*"+dst+")", src, elem, toShadow, 1, 1)
+		}
+	}
+	if dst == "" {
+		g.Printf("	return %s\n", v)
+	}
+}
+
+// convert emits statements assigning the addressable value src of the
+// source type expr, at the given level of a field type, to dst, converting
+// values of generated types on the way. depth numbers the variables of
+// nested loops.
+func (g *Generator) convert(dst string, src string, expr ast.Expr, toShadow bool, level int, depth int) {
+	if !g.needsConversion(expr, level) {
+		g.Printf("%s = %s\n", dst, src)
+		return
+	}
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if toShadow && *valueConstructor {
+			g.Printf("%s = %s\n", dst, g.newCall(t.Name, "&"+src))
+		} else if toShadow {
+			g.Printf("%s = *%s\n", dst, g.newCall(t.Name, "&"+src))
+		} else {
+			g.Printf("%s = %s.To%s()\n", dst, src, t.Name)
+		}
+	case *ast.StarExpr:
+		g.Printf("if %s != nil {\n", src)
+		if ident, ok := t.X.(*ast.Ident); ok {
+			if toShadow && !*valueConstructor {
+				g.Printf("%s = %s\n", dst, g.newCall(ident.Name, src))
+			} else if toShadow {
+				g.Printf("p%d := %s\n", depth, g.newCall(ident.Name, src))
+				g.Printf("%s = &p%d\n", dst, depth)
+			} else {
+				g.Printf("p%d := %s.To%s()\n", depth, src, ident.Name)
+				g.Printf("%s = &p%d\n", dst, depth)
+			}
+		} else {
+			g.Printf("%s = new(%s)\n", dst, g.typeOf(t.X, toShadow, level))
+			g.convert("(*"+dst+")", "(*"+src+")", t.X, toShadow, level, depth+1)
+		}
+		g.Printf("}\n")
+	case *ast.ArrayType:
+		if t.Len == nil {
+			g.Printf("if %s != nil {\n", src)
+			g.Printf("%s = make(%s, len(%s))\n", dst, g.typeOf(t, toShadow, level), src)
+		}
+		g.Printf("for i%d := range %s {\n", depth, src)
+		index := fmt.Sprintf("[i%d]", depth)
+		g.convert(dst+index, src+index, t.Elt, toShadow, level+1, depth+1)
+		g.Printf("}\n")
+		if t.Len == nil {
+			g.Printf("}\n")
+		}
+	case *ast.MapType:
+		g.Printf("if %s != nil {\n", src)
+		g.Printf("%s = make(%s, len(%s))\n", dst, g.typeOf(t, toShadow, level), src)
+		g.Printf("for k%d, e%d := range %s {\n", depth, depth, src)
+		g.Printf("var c%d %s\n", depth, g.typeOf(t.Value, toShadow, level+1))
+		g.convert(fmt.Sprintf("c%d", depth), fmt.Sprintf("e%d", depth), t.Value, toShadow, level+1, depth+1)
+		g.Printf("%s[k%d] = c%d\n", dst, depth, depth)
+		g.Printf("}\n")
+		g.Printf("}\n")
+	case *ast.StructType:
+		for _, field := range t.Fields.List {
+			if len(field.Names) == 0 {
+				name := embeddedFieldName(field.Type)
+				dstName, srcName := name, name
+				if g.needsConversion(field.Type, level+1) {
+					if toShadow {
+						dstName += g.suffix
+					} else {
+						srcName += g.suffix
+					}
+				}
+				g.convert(dst+"."+dstName, src+"."+srcName, field.Type, toShadow, level+1, depth)
+				continue
+			}
+			for _, ident := range field.Names {
+				g.convert(dst+"."+ident.Name, src+"."+ident.Name, field.Type, toShadow, level+1, depth)
+			}
+		}
+	}
+}
+
+// typeOf returns the type expr, at the given level of a field type, in
+// the generated struct if shadow is set, and in the source type otherwise.
+// Unlike types.ExprString, it keeps the tags of anonymous structs, which
+// are part of their type.
+func (g *Generator) typeOf(expr ast.Expr, shadow bool, level int) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if shadow && g.needsConversion(t, level) {
+			return t.Name + g.suffix
+		}
+	case *ast.StarExpr:
+		return "*" + g.typeOf(t.X, shadow, level)
+	case *ast.ArrayType:
+		if t.Len == nil {
+			return "[]" + g.typeOf(t.Elt, shadow, level+1)
+		}
+		return "[" + types.ExprString(t.Len) + "]" + g.typeOf(t.Elt, shadow, level+1)
+	case *ast.MapType:
+		return "map[" + types.ExprString(t.Key) + "]" + g.typeOf(t.Value, shadow, level+1)
+	case *ast.StructType:
+		return g.structType(t, shadow, level)
+	}
+	return types.ExprString(expr)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"go/types"
+	"io"
+)
+
+// Plan is the document -plan-json prints instead of writing the code.
+type Plan struct {
+	Package string     `json:"package"`
+	Output  string     `json:"output"` // output file the code would be written to
+	Types   []PlanType `json:"types"`
+}
+
+// PlanType describes a type that would be generated.
+type PlanType struct {
+	Name      string      `json:"name"`      // name of the source type
+	Generated string      `json:"generated"` // name of the generated struct, e.g. UserJSON
+	Pos       string      `json:"pos"`       // file:line of the source type, relative to the output
+	Fields    []PlanField `json:"fields"`
+	Skipped   []Skip      `json:"skipped,omitempty"`
+}
+
+// PlanField describes a field of a generated struct.
+type PlanField struct {
+	Name          string `json:"name"`          // name of the field, or the type name if embedded
+	Type          string `json:"type"`          // Go type of the field in the source type
+	GeneratedType string `json:"generatedType"` // Go type of the field in the generated struct
+	Key           string `json:"key,omitempty"` // key given by the generated tag, e.g. user_name
+	Tag           string `json:"tag,omitempty"` // generated tag, without quotes
+	Embedded      bool   `json:"embedded,omitempty"`
+}
+
+// writePlan writes the Plan of the types to w as indented JSON.
+func (g *Generator) writePlan(w io.Writer, outputName string) error {
+	plan := Plan{Package: g.pkg.name, Output: outputName, Types: []PlanType{}}
+	for _, t := range g.types {
+		fields := g.fields(t.Name, t.Struct)
+		if *skipNoop && !*nested && g.isNoop(fields) {
+			continue
+		}
+		pt := PlanType{
+			Name:      t.Name,
+			Generated: t.Name + g.suffix,
+			Pos:       t.Pos,
+			Fields:    []PlanField{},
+			Skipped:   g.stats[len(g.stats)-1].Skips,
+		}
+		for _, f := range fields {
+			pt.Fields = append(pt.Fields, PlanField{
+				Name:          f.Name,
+				Type:          types.ExprString(f.Type),
+				GeneratedType: g.fieldType(f),
+				Key:           f.Key,
+				Tag:           unquoteTag(f.Tag),
+				Embedded:      f.Embedded,
+			})
+		}
+		plan.Types = append(plan.Types, pt)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
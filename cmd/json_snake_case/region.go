@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Markers delimiting the region of a hand-written file that -inline-region replaces.
+const (
+	regionBegin = "// json_snake:begin"
+	regionEnd   = "// json_snake:end"
+)
+
+// Markers delimiting the code generated for each type with -output-mode=merge.
+// They are followed by the type name.
+const (
+	typeBegin = "// json_snake:type"
+	typeEnd   = "// json_snake:type-end"
+)
+
+// useImports makes the generated code refer to the packages imported by
+// the named file by the names that file uses. It returns the package name
+// of the file.
+func (g *Generator) useImports(name string) (string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.ImportsOnly)
+	if err != nil {
+		return "", err
+	}
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return "", err
+		}
+		localName := path.Base(importPath)
+		if spec.Name != nil {
+			localName = spec.Name.Name
+		}
+		if localName == "_" || localName == "." {
+			continue
+		}
+		if g.imports == nil {
+			g.imports = make(map[string]string)
+		}
+		g.imports[importPath] = localName
+		g.fileImports = append(g.fileImports, importPath)
+	}
+	return file.Name.Name, nil
+}
+
+// writeLines writes lines to buf, adding the imports the generated code
+// needs that the file of lines lacks after its package clause.
+func (g *Generator) writeLines(buf *bytes.Buffer, lines []string) {
+	var missing []string
+	for importPath := range g.imports {
+		if !contains(g.fileImports, importPath) {
+			missing = append(missing, importPath)
+		}
+	}
+	sort.Strings(missing)
+
+	for _, line := range lines {
+		buf.WriteString(line)
+		if !strings.HasPrefix(line, "package ") {
+			continue
+		}
+		for _, importPath := range missing {
+			if localName := g.imports[importPath]; localName != path.Base(importPath) {
+				fmt.Fprintf(buf, "\nimport %s %q\n", localName, importPath)
+			} else {
+				fmt.Fprintf(buf, "\nimport %q\n", importPath)
+			}
+		}
+		missing = nil
+	}
+}
+
+// generatedRegion returns the positions of the -inline-region markers in
+// file, or token.NoPos for both if it lacks them. The types declared in
+// between were generated by an earlier run, and aren't generated for.
+func generatedRegion(file *ast.File) (begin, end token.Pos) {
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			switch strings.TrimSpace(c.Text) {
+			case regionBegin:
+				if !begin.IsValid() {
+					begin = c.Pos()
+				}
+			case regionEnd:
+				if begin.IsValid() && !end.IsValid() {
+					end = c.Pos()
+				}
+			}
+		}
+	}
+	if !end.IsValid() {
+		return token.NoPos, token.NoPos
+	}
+	return begin, end
+}
+
+// replaceRegion returns the contents of the named file with the lines
+// between the region markers replaced by the generated declarations.
+// Imports the declarations need that the file lacks are added after
+// its package clause. Everything else in the file is kept as it is.
+func (g *Generator) replaceRegion(name string) ([]byte, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(src), "\n")
+	begin, end := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case regionBegin:
+			if begin < 0 {
+				begin = i
+			}
+		case regionEnd:
+			if begin >= 0 && end < 0 {
+				end = i
+			}
+		}
+	}
+	if begin < 0 || end < 0 {
+		return nil, fmt.Errorf("%s: missing %q and %q marker lines", name, regionBegin, regionEnd)
+	}
+
+	var buf bytes.Buffer
+	g.writeLines(&buf, lines[:begin+1])
+	buf.WriteString("\n")
+	buf.Write(bytes.TrimRight(g.buf.Bytes(), "\n"))
+	buf.WriteString("\n")
+	for _, line := range lines[end:] {
+		buf.WriteString(line)
+	}
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid Go generated: %s", name, err)
+	}
+	return out, nil
+}
+
+// appendTo returns the contents of the named file followed by the
+// generated declarations. It is an error if the file already declares
+// one of them.
+func (g *Generator) appendTo(name string) ([]byte, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), name, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range g.types {
+		if file.Scope.Lookup(t.Name+g.suffix) != nil {
+			return nil, fmt.Errorf("%s already declares %s%s", name, t.Name, g.suffix)
+		}
+	}
+
+	var buf bytes.Buffer
+	g.writeLines(&buf, strings.SplitAfter(string(src), "\n"))
+	buf.WriteString("\n")
+	buf.Write(g.buf.Bytes())
+
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid Go generated: %s", name, err)
+	}
+	return out, nil
+}
+
+// segment is a run of lines of a file, either the code generated for the
+// named type between its markers, or other lines if name is empty.
+type segment struct {
+	name  string
+	lines []string
+}
+
+// typeSegments splits lines into segments at the merge markers.
+func typeSegments(lines []string) []segment {
+	var segments []segment
+	current := segment{}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if current.name == "" && strings.HasPrefix(trimmed, typeBegin+" ") {
+			if len(current.lines) > 0 {
+				segments = append(segments, current)
+			}
+			current = segment{name: strings.TrimSpace(strings.TrimPrefix(trimmed, typeBegin))}
+		}
+		current.lines = append(current.lines, line)
+		if current.name != "" && trimmed == typeEnd+" "+current.name {
+			segments = append(segments, current)
+			current = segment{}
+		}
+	}
+	if len(current.lines) > 0 {
+		segments = append(segments, current)
+	}
+	return segments
+}
+
+// mergeInto returns the contents of the named file, written with
+// -output-mode=merge before, with the code of the types generated now
+// replacing their earlier code, or added at the end for new types. The
+// code of other types is kept, and imports no code uses any more are
+// dropped.
+func (g *Generator) mergeInto(name string) ([]byte, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	segments := typeSegments(strings.SplitAfter(string(src), "\n"))
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("%s has no %q markers; it must be written with -output-mode=merge", name, typeBegin)
+	}
+	generated := make(map[string][]string)
+	for _, s := range typeSegments(strings.SplitAfter(g.buf.String(), "\n")) {
+		if s.name != "" {
+			generated[s.name] = s.lines
+		}
+	}
+
+	var lines []string
+	for _, s := range segments {
+		if s.name != "" && generated[s.name] != nil {
+			s.lines = generated[s.name]
+			delete(generated, s.name)
+		}
+		lines = append(lines, s.lines...)
+	}
+	for _, t := range g.types {
+		if code := generated[t.Name]; code != nil {
+			lines = append(lines, "\n")
+			lines = append(lines, code...)
+		}
+	}
+
+	var buf bytes.Buffer
+	g.writeLines(&buf, lines)
+	out, err := pruneImports(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid Go generated: %s", name, err)
+	}
+	return out, nil
+}
+
+// pruneImports returns src formatted, without the imports it doesn't use.
+// Packages are assumed to be named after the last element of their path,
+// as in useImports.
+func pruneImports(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+	var decls []ast.Decl
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			decls = append(decls, decl)
+			continue
+		}
+		var specs []ast.Spec
+		for _, spec := range genDecl.Specs {
+			importSpec := spec.(*ast.ImportSpec)
+			importPath, err := strconv.Unquote(importSpec.Path.Value)
+			if err != nil {
+				return nil, err
+			}
+			localName := path.Base(importPath)
+			if importSpec.Name != nil {
+				localName = importSpec.Name.Name
+			}
+			if localName == "_" || localName == "." || used[localName] {
+				specs = append(specs, spec)
+			}
+		}
+		if len(specs) > 0 {
+			genDecl.Specs = specs
+			decls = append(decls, genDecl)
+		}
+	}
+	file.Decls = decls
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
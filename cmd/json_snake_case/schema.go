@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// Schema is a JSON Schema (draft-07) document or subschema.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// schemaType is a type recorded for the schema, in generation order.
+type schemaType struct {
+	name   string
+	fields []Field
+}
+
+// addSchema records the fields of the named type for generateSchema.
+func (g *Generator) addSchema(name string, fields []Field) {
+	g.schemaTypes = append(g.schemaTypes, schemaType{name: name, fields: fields})
+}
+
+// generateSchema returns a JSON Schema document with a definition for each
+// recorded type, describing the JSON the generated MarshalJSON produces.
+func (g *Generator) generateSchema() ([]byte, error) {
+	doc := &Schema{
+		Schema:      "http://json-schema.org/draft-07/schema#",
+		Definitions: make(map[string]*Schema),
+	}
+	for _, t := range g.schemaTypes {
+		doc.Definitions[t.name] = &Schema{}
+	}
+	for _, t := range g.schemaTypes {
+		def := doc.Definitions[t.name]
+		def.Type = "object"
+		def.Properties = make(map[string]*Schema)
+		for _, f := range t.fields {
+			if f.Embedded {
+				// encoding/json promotes the fields of embedded structs.
+				if name := embeddedFieldName(f.Type); doc.Definitions[name] != nil {
+					def.AllOf = append(def.AllOf, &Schema{Ref: "#/definitions/" + name})
+				} else {
+					verbosef("%s.%s: fields of embedded %s are not described in the schema", t.name, f.Name, types.ExprString(f.Type))
+				}
+				continue
+			}
+			value, _ := tagParser(unquoteTag(f.Tag)).Lookup(*tag)
+			if value == "-" {
+				// Skipped, unlike a field tagged "-," which is named "-".
+				continue
+			}
+			fieldType := f.Type
+			if f.Mapped != nil {
+				fieldType = f.Mapped
+			}
+			property := g.typeSchema(fieldType, doc.Definitions)
+			property.Description = fieldComment(f.Source)
+			def.Properties[f.Key] = property
+			if options := strings.Split(value, ",")[1:]; !contains(options, "omitempty") && !contains(options, "omitzero") {
+				def.Required = append(def.Required, f.Key)
+			}
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// typeSchema returns the schema of values of the Go type expr. Types other
+// than the predeclared ones, composites of them and the types in definitions
+// can't be resolved from the syntax alone and allow any value, unless
+// -type-check resolves them to a basic type.
+func (g *Generator) typeSchema(expr ast.Expr, definitions map[string]*Schema) *Schema {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if s := basicSchema(t.Name); s != nil {
+			return s
+		}
+		if definitions[t.Name] != nil {
+			return &Schema{Ref: "#/definitions/" + t.Name}
+		}
+	case *ast.StarExpr:
+		return g.typeSchema(t.X, definitions)
+	case *ast.ArrayType:
+		if elt, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (elt.Name == "byte" || elt.Name == "uint8") {
+			// encoding/json encodes []byte as a base64 string.
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.typeSchema(t.Elt, definitions)}
+	case *ast.MapType:
+		return &Schema{Type: "object", AdditionalProperties: g.typeSchema(t.Value, definitions)}
+	case *ast.SelectorExpr:
+		if types.ExprString(t) == "time.Time" {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+	}
+	// Named types may marshal themselves in any way, so only aliases
+	// and types without methods are resolved.
+	if t := g.pkg.typeOf(expr); t != nil {
+		if named, ok := t.(*types.Named); !ok || named.NumMethods() == 0 {
+			if basic, ok := t.Underlying().(*types.Basic); ok {
+				if s := basicSchema(basic.Name()); s != nil {
+					return s
+				}
+			}
+		}
+	}
+	return &Schema{}
+}
+
+// basicSchema returns the schema of values of the named predeclared type,
+// or nil if name isn't one with a schema.
+func basicSchema(name string) *Schema {
+	switch name {
+	case "string":
+		return &Schema{Type: "string"}
+	case "bool":
+		return &Schema{Type: "boolean"}
+	case "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune":
+		return &Schema{Type: "integer"}
+	case "float32", "float64":
+		return &Schema{Type: "number"}
+	}
+	return nil
+}
+
+// fieldComment returns the text of the doc and line comments of field on one line.
+func fieldComment(field *ast.Field) string {
+	text := field.Doc.Text() + " " + field.Comment.Text()
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	_ "embed"
+	"go/types"
+	"io/ioutil"
+	"text/template"
+)
+
+// defaultTemplate renders the built-in output for fields copied as they
+// are, as a starting point for custom templates. It leaves out the
+// conversions of fields, e.g. those of -nested, and other options that
+// change the built-in methods.
+//
+//go:embed default.tmpl
+var defaultTemplate string
+
+// TemplateData is the data a -template is executed with.
+type TemplateData struct {
+	Package string         // name of the package of the output file
+	Suffix  string         // suffix of the generated type names, e.g. JSON
+	Tag     string         // key of the generated tags, e.g. json
+	Test    bool           // whether the output is a _test.go file, with -test
+	Types   []TemplateType // types to generate, in source order
+}
+
+// TemplateType describes a type to generate.
+type TemplateType struct {
+	Name       string // name of the source type
+	TypeParams string // type parameter list of a generic type, e.g. [T any], or ""
+	TypeArgs   string // its type parameters as type arguments, e.g. [T], or ""
+	Doc        string // doc comment of the source type, without comment markers
+	Pos        string // file:line of the source type, relative to the output
+	Fields     []TemplateField
+}
+
+// TemplateField describes a field of a type to generate.
+type TemplateField struct {
+	Name       string // name of the field, or the type name if embedded
+	Type       string // Go type of the field in the source type
+	ShadowType string // Go type of the field in the generated type
+	Key        string // key given by the generated tag, e.g. user_name
+	Tag        string // generated tag as a raw string literal, or ""
+	Embedded   bool
+}
+
+// parseTemplate reads and parses the named template file. The template
+// calls import with a package path to get the name to refer to it by, and
+// comment with text to get it as comment lines.
+func (g *Generator) parseTemplate(name string) (*template.Template, error) {
+	text, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Funcs(template.FuncMap{
+		"import":  g.addImport,
+		"comment": comment,
+	}).Parse(string(text))
+}
+
+// generateTemplate executes tmpl, writing to the Generator's buffer in
+// place of the built-in declarations.
+func (g *Generator) generateTemplate(tmpl *template.Template) error {
+	data := TemplateData{Package: g.pkg.name, Suffix: g.suffix, Tag: *tag, Test: *test}
+	for _, t := range g.types {
+		fields := g.fields(t.Name, t.Struct)
+		if g.skipNoop(t.Name, fields) {
+			continue
+		}
+		warnFieldCount(t.Name, fields)
+		if *schema {
+			g.addSchema(t.Name, fields)
+		}
+		tt := TemplateType{Name: t.Name, TypeParams: t.typeParams(), TypeArgs: t.typeArgs(), Doc: t.Doc.Text(), Pos: t.Pos}
+		for _, f := range fields {
+			tt.Fields = append(tt.Fields, TemplateField{
+				Name:       f.Name,
+				Type:       types.ExprString(f.Type),
+				ShadowType: g.fieldType(f),
+				Key:        f.Key,
+				Tag:        f.Tag,
+				Embedded:   f.Embedded,
+			})
+		}
+		data.Types = append(data.Types, tt)
+	}
+	return tmpl.Execute(&g.buf, data)
+}
@@ -0,0 +1,71 @@
+package bench
+
+import "testing"
+
+var sink []byte
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	tags := []string{"admin", "staff"}
+	b.Run("plain", func(b *testing.B) {
+		b.ReportAllocs()
+		v := Plain{UserID: 1, UserName: "gopher", Email: "gopher@example.com", CreatedAt: 1700000000, Tags: tags}
+		for i := 0; i < b.N; i++ {
+			var err error
+			if sink, err = v.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		v := Pooled{UserID: 1, UserName: "gopher", Email: "gopher@example.com", CreatedAt: 1700000000, Tags: tags}
+		for i := 0; i < b.N; i++ {
+			var err error
+			if sink, err = v.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+var (
+	pointerSink      *PlainJSON
+	valueSink        ByValueJSON
+	pointerSliceSink []*PlainJSON
+	valueSliceSink   []ByValueJSON
+)
+
+func BenchmarkConstructor(b *testing.B) {
+	tags := []string{"admin", "staff"}
+	b.Run("pointer", func(b *testing.B) {
+		b.ReportAllocs()
+		v := Plain{UserID: 1, UserName: "gopher", Email: "gopher@example.com", CreatedAt: 1700000000, Tags: tags}
+		for i := 0; i < b.N; i++ {
+			pointerSink = NewPlainJSON(&v)
+		}
+	})
+	b.Run("value", func(b *testing.B) {
+		b.ReportAllocs()
+		v := ByValue{UserID: 1, UserName: "gopher", Email: "gopher@example.com", CreatedAt: 1700000000, Tags: tags}
+		for i := 0; i < b.N; i++ {
+			valueSink = NewByValueJSON(&v)
+		}
+	})
+}
+
+func BenchmarkSliceHelper(b *testing.B) {
+	plain := make([]Plain, 100)
+	byValue := make([]ByValue, 100)
+	b.Run("pointer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			pointerSliceSink = NewPlainJSONSlice(plain)
+		}
+	})
+	b.Run("value", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			valueSliceSink = NewByValueJSONSlice(byValue)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+// Code generated by "json_snake_case -type=ByValue -value-constructor -gen-slice-helper -output=byvalue_json.go"; DO NOT EDIT.
+
+package bench
+
+import "encoding/json"
+
+// ByValueJSON is the JSON serialization view of ByValue.
+//
+// ByValue is Plain, converted by value by -value-constructor.
+type ByValueJSON struct {
+	UserID    int      `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	Email     string   `json:"email"`
+	CreatedAt int64    `json:"created_at"`
+	Tags      []string `json:"tags"`
+}
+
+func (m ByValue) MarshalJSON() ([]byte, error) {
+	j := NewByValueJSON(&m)
+	return json.Marshal(&j)
+}
+
+func NewByValueJSON(m *ByValue) ByValueJSON {
+	return ByValueJSON{
+		UserID:    m.UserID,
+		UserName:  m.UserName,
+		Email:     m.Email,
+		CreatedAt: m.CreatedAt,
+		Tags:      m.Tags,
+	}
+}
+
+func (j *ByValueJSON) ToByValue() ByValue {
+	return ByValue{
+		UserID:    j.UserID,
+		UserName:  j.UserName,
+		Email:     j.Email,
+		CreatedAt: j.CreatedAt,
+		Tags:      j.Tags,
+	}
+}
+
+func NewByValueJSONSlice(ms []ByValue) []ByValueJSON {
+	if ms == nil {
+		return nil
+	}
+	js := make([]ByValueJSON, len(ms))
+	for i := range ms {
+		js[i] = NewByValueJSON(&ms[i])
+	}
+	return js
+}
@@ -0,0 +1,38 @@
+// Package bench compares the allocations of the methods generated with
+// and without the options trading them, see bench_test.go. Run it with
+//
+//	go test -bench . -benchmem ./cmd/json_snake_case/testdata/bench
+//
+// and regenerate it with go generate after changing the generator.
+package bench
+
+//go:generate json_snake_case -type=Plain -gen-slice-helper -output=plain_json.go
+//go:generate json_snake_case -type=Pooled -buffer-pool -output=pooled_json.go
+//go:generate json_snake_case -type=ByValue -value-constructor -gen-slice-helper -output=byvalue_json.go
+
+// Plain is encoded through json.Marshal.
+type Plain struct {
+	UserID    int
+	UserName  string
+	Email     string
+	CreatedAt int64
+	Tags      []string
+}
+
+// Pooled is Plain, encoded through the pooled buffers of -buffer-pool.
+type Pooled struct {
+	UserID    int
+	UserName  string
+	Email     string
+	CreatedAt int64
+	Tags      []string
+}
+
+// ByValue is Plain, converted by value by -value-constructor.
+type ByValue struct {
+	UserID    int
+	UserName  string
+	Email     string
+	CreatedAt int64
+	Tags      []string
+}
@@ -0,0 +1,87 @@
+// Code generated by "json_snake_case -type=Pooled -buffer-pool -output=pooled_json.go"; DO NOT EDIT.
+
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// PooledJSON is the JSON serialization view of Pooled.
+//
+// Pooled is Plain, encoded through the pooled buffers of -buffer-pool.
+type PooledJSON struct {
+	UserID    int      `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	Email     string   `json:"email"`
+	CreatedAt int64    `json:"created_at"`
+	Tags      []string `json:"tags"`
+}
+
+// pooledJSONEncoder is a reusable buffer and the encoder writing to it.
+type pooledJSONEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var pooledJSONEncoders = sync.Pool{
+	New: func() interface{} {
+		e := new(pooledJSONEncoder)
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+// pooledJSONMarshal is like json.Marshal, but reuses a pooled buffer and encoder.
+func pooledJSONMarshal(v interface{}) ([]byte, error) {
+	e := pooledJSONEncoders.Get().(*pooledJSONEncoder)
+	defer pooledJSONEncoders.Put(e)
+	e.buf.Reset()
+	if err := e.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode terminates the value with a newline, which Marshal doesn't.
+	b := e.buf.Bytes()
+	return append([]byte(nil), b[:len(b)-1]...), nil
+}
+
+var pooledJSONValues = sync.Pool{
+	New: func() interface{} { return new(PooledJSON) },
+}
+
+func (m Pooled) MarshalJSON() ([]byte, error) {
+	j := pooledJSONValues.Get().(*PooledJSON)
+	defer func() {
+		*j = PooledJSON{}
+		pooledJSONValues.Put(j)
+	}()
+	*j = PooledJSON{
+		UserID:    m.UserID,
+		UserName:  m.UserName,
+		Email:     m.Email,
+		CreatedAt: m.CreatedAt,
+		Tags:      m.Tags,
+	}
+	return pooledJSONMarshal(j)
+}
+
+func NewPooledJSON(m *Pooled) *PooledJSON {
+	return &PooledJSON{
+		UserID:    m.UserID,
+		UserName:  m.UserName,
+		Email:     m.Email,
+		CreatedAt: m.CreatedAt,
+		Tags:      m.Tags,
+	}
+}
+
+func (j *PooledJSON) ToPooled() Pooled {
+	return Pooled{
+		UserID:    j.UserID,
+		UserName:  j.UserName,
+		Email:     j.Email,
+		CreatedAt: j.CreatedAt,
+		Tags:      j.Tags,
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+)
+
+// check type-checks the parsed files of the package for -type-check,
+// importing dependencies from source. Errors, e.g. references to code that
+// is yet to be generated, are logged under -v: the types that could be
+// resolved are still recorded.
+func (pkg *Package) check(fs *token.FileSet) {
+	var files []*ast.File
+	for _, file := range pkg.files {
+		files = append(files, file.AstFile)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fs, "source", nil),
+		Error: func(err error) {
+			verbosef("type-checking: %s", err)
+		},
+	}
+	pkg.info = &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	conf.Check(pkg.name, fs, files, pkg.info)
+}
+
+// typeOf returns the type of the type expression expr, or nil if the
+// package isn't type-checked or expr couldn't be resolved.
+func (pkg *Package) typeOf(expr ast.Expr) types.Type {
+	if pkg.info == nil {
+		return nil
+	}
+	tv, ok := pkg.info.Types[expr]
+	if !ok || !tv.IsType() {
+		return nil
+	}
+	return tv.Type
+}
+
+// checkOutput type-checks the package with src as the contents of the
+// output file outputName for -check-output, importing dependencies from
+// source, and returns the errors located in src. Errors in other files
+// are logged under -v, as with check. Files excluded by build
+// constraints are left out, as they may declare the same names.
+func (pkg *Package) checkOutput(fs *token.FileSet, outputName string, src []byte) ([]error, error) {
+	output, err := parser.ParseFile(fs, outputName, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	files := []*ast.File{output}
+	for _, file := range pkg.files {
+		if sameFile(file.Name, outputName) || contains(pkg.ignored, file.Name) {
+			continue
+		}
+		files = append(files, file.AstFile)
+	}
+	var errs []error
+	conf := types.Config{
+		Importer: importer.ForCompiler(fs, "source", nil),
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok && fs.File(typeErr.Pos) == fs.File(output.Pos()) {
+				errs = append(errs, err)
+				return
+			}
+			verbosef("type-checking: %s", err)
+		},
+	}
+	conf.Check(pkg.name, fs, files, nil)
+	return errs, nil
+}
+
+// sameFile reports whether the file names a and b refer to the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}